@@ -0,0 +1,317 @@
+/*
+ * Nuts go core
+ * Copyright (C) 2020 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package core
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+const authEnforceFlag = "auth.enforce"
+const authTokenFileFlag = "auth.tokenfile"
+const authClientCertFlag = "auth.clientcert"
+
+// TokenAuthMethod is the authentication method of principals authenticated by an API key or bearer token
+const TokenAuthMethod = "token"
+
+// ClientCertAuthMethod is the authentication method of principals authenticated by a client certificate
+const ClientCertAuthMethod = "clientcert"
+
+// SocketAuthMethod is the authentication method of principals connected through a unix domain socket
+const SocketAuthMethod = "socket"
+
+// SocketPrincipalName is the name of principals connected through a unix domain socket
+const SocketPrincipalName = "local"
+
+// APIKeyHeader is the request header holding an API key, as alternative to a bearer token in the Authorization header
+const APIKeyHeader = "X-API-Key"
+
+// authErrorKey is the echo context key holding the error authenticating a request, see Authentication.identify
+const authErrorKey = "nuts.auth.error"
+
+// ErrUnauthenticated is returned when a request for a protected route isn't authenticated
+var ErrUnauthenticated = errors.New("authentication required")
+
+// ErrInvalidCredentials is returned by an Authenticator when the credentials of a request are invalid
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// ErrInvalidAuthConfig is returned when the authentication options are invalid
+var ErrInvalidAuthConfig = errors.New("invalid authentication config")
+
+// AuthOptions holds the authentication options of the node's HTTP server
+type AuthOptions struct {
+	// Enforce rejects unauthenticated requests for protected routes, it's always set in strict mode
+	Enforce bool
+	// TokenFile is the file holding the accepted API keys and bearer tokens, one "<principal> <token>" per line
+	TokenFile string
+	// ClientCert authenticates requests by their verified client certificate
+	ClientCert bool
+}
+
+// validate checks the options against the TLS options, without reading any files
+func (o AuthOptions) validate(tlsOptions TLSOptions) error {
+	if !o.ClientCert {
+		return nil
+	}
+	if !tlsOptions.Enabled() {
+		return fmt.Errorf("%w: %s requires TLS", ErrInvalidAuthConfig, authClientCertFlag)
+	}
+	if clientAuth := tlsClientAuthTypes[tlsOptions.ClientAuth]; clientAuth != tls.VerifyClientCertIfGiven && clientAuth != tls.RequireAndVerifyClientCert {
+		return fmt.Errorf("%w: %s requires %s verifyifgiven or verify", ErrInvalidAuthConfig, authClientCertFlag, tlsClientAuthFlag)
+	}
+	return nil
+}
+
+// Principal is the authenticated client of a request
+type Principal struct {
+	// Name identifies the client: the name of the token, the common name of the client certificate or SocketPrincipalName
+	Name string
+	// Method is the authentication method: TokenAuthMethod, ClientCertAuthMethod, SocketAuthMethod or a custom one
+	Method string
+	// Certificate is the verified client certificate, when authenticated by ClientCertAuthMethod
+	Certificate *x509.Certificate
+}
+
+type principalKey struct{}
+
+// PrincipalFrom returns the principal of the request the context belongs to, nil when the request isn't authenticated
+func PrincipalFrom(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalKey{}).(*Principal)
+	return principal
+}
+
+// Authenticator authenticates requests by a single method. It returns nil without error when the request holds no
+// credentials for that method, and an error wrapping ErrInvalidCredentials when the credentials are invalid.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// TokenAuthenticator authenticates requests by a bearer token in the Authorization header or an API key in the X-API-Key header
+type TokenAuthenticator struct {
+	// tokens maps the SHA-256 digest of a token to its principal name
+	tokens map[[sha256.Size]byte]string
+}
+
+// NewTokenAuthenticator creates a TokenAuthenticator accepting the given tokens, mapped to the name of their principal
+func NewTokenAuthenticator(tokens map[string]string) *TokenAuthenticator {
+	a := &TokenAuthenticator{tokens: make(map[[sha256.Size]byte]string, len(tokens))}
+	for token, name := range tokens {
+		a.tokens[sha256.Sum256([]byte(token))] = name
+	}
+	return a
+}
+
+// LoadTokenFile creates a TokenAuthenticator accepting the tokens in the given file. Every line holds the principal name
+// and the token separated by whitespace, empty lines and lines starting with # are ignored.
+func LoadTokenFile(file string) (*TokenAuthenticator, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("%w: unable to read token file: %v", ErrInvalidAuthConfig, err)
+	}
+	defer f.Close()
+
+	tokens := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%w: line %d of %s: expected <principal> <token>", ErrInvalidAuthConfig, n, file)
+		}
+		tokens[fields[1]] = fields[0]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: unable to read token file: %v", ErrInvalidAuthConfig, err)
+	}
+	return NewTokenAuthenticator(tokens), nil
+}
+
+// Authenticate returns the principal of the token of the request
+func (a *TokenAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	token := r.Header.Get(APIKeyHeader)
+	if authorization := r.Header.Get(echo.HeaderAuthorization); token == "" && authorization != "" {
+		const bearer = "Bearer "
+		if len(authorization) < len(bearer) || !strings.EqualFold(authorization[:len(bearer)], bearer) {
+			return nil, nil
+		}
+		token = authorization[len(bearer):]
+	}
+	if token == "" {
+		return nil, nil
+	}
+	// compare digests in constant time, so the response time doesn't reveal how much of a token matches
+	digest := sha256.Sum256([]byte(token))
+	name, found := "", false
+	for known, n := range a.tokens {
+		if subtle.ConstantTimeCompare(digest[:], known[:]) == 1 {
+			name, found = n, true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("%w: unknown token", ErrInvalidCredentials)
+	}
+	return &Principal{Name: name, Method: TokenAuthMethod}, nil
+}
+
+// ClientCertAuthenticator authenticates requests by the client certificate verified during the TLS handshake.
+// The name of the principal is the common name of the certificate.
+type ClientCertAuthenticator struct{}
+
+// Authenticate returns the principal of the verified client certificate of the request
+func (ClientCertAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, nil
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	return &Principal{Name: cert.Subject.CommonName, Method: ClientCertAuthMethod, Certificate: cert}, nil
+}
+
+// SocketAuthenticator authenticates requests received on a unix domain socket. Access to the socket is controlled by
+// the permissions of the socket file, so every client able to connect is authenticated as SocketPrincipalName.
+type SocketAuthenticator struct{}
+
+// Authenticate returns the principal of a request received on a unix domain socket
+func (SocketAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); !ok || addr.Network() != "unix" {
+		return nil, nil
+	}
+	return &Principal{Name: SocketPrincipalName, Method: SocketAuthMethod}, nil
+}
+
+// Authentication authenticates the requests for the routes of the engines using its authenticators, in order.
+// Authenticators must be added before the server is started.
+type Authentication struct {
+	// Enforce rejects unauthenticated requests for protected routes. When not set, they are handled without principal.
+	Enforce        bool
+	authenticators []Authenticator
+}
+
+// NewAuthentication creates an Authentication with the built-in authenticators enabled by the given options
+func NewAuthentication(options AuthOptions) (*Authentication, error) {
+	a := &Authentication{Enforce: options.Enforce}
+	if options.TokenFile != "" {
+		tokens, err := LoadTokenFile(options.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		a.Add(tokens)
+	}
+	if options.ClientCert {
+		a.Add(ClientCertAuthenticator{})
+	}
+	return a, nil
+}
+
+// Add adds an authenticator, which is tried after the authenticators added before
+func (a *Authentication) Add(authenticator Authenticator) {
+	a.authenticators = append(a.authenticators, authenticator)
+}
+
+// check returns an error when authentication is enforced without authenticators, which would reject every request
+func (a *Authentication) check() error {
+	if a.Enforce && len(a.authenticators) == 0 {
+		return fmt.Errorf("%w: authentication is enforced but no authenticator is configured, configure %s or %s", ErrInvalidAuthConfig, authTokenFileFlag, authClientCertFlag)
+	}
+	return nil
+}
+
+// authenticate returns the principal of the request, nil when the request holds no credentials
+func (a *Authentication) authenticate(r *http.Request) (*Principal, error) {
+	for _, authenticator := range a.authenticators {
+		principal, err := authenticator.Authenticate(r)
+		if err != nil || principal != nil {
+			return principal, err
+		}
+	}
+	return nil, nil
+}
+
+// Middleware authenticates the requests of a protected route. It stores the principal in the context of the request, see
+// PrincipalFrom. Requests with invalid credentials are rejected, as are requests without credentials when authentication is enforced.
+// The routes of engines get this middleware split in two, with the limits in between: see identify and enforce.
+func (a *Authentication) Middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return a.identify(a.enforce(false)(next))
+}
+
+// identify is the middleware storing the principal of the request in its context. The error of invalid credentials is
+// stored in the echo context, to be handled by enforce.
+func (a *Authentication) identify(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		principal, err := a.authenticate(c.Request())
+		if err != nil {
+			c.Set(authErrorKey, err)
+		}
+		if principal != nil {
+			c.SetRequest(c.Request().WithContext(context.WithValue(c.Request().Context(), principalKey{}, principal)))
+		}
+		return next(c)
+	}
+}
+
+// enforce returns the middleware rejecting the requests identify found invalid credentials for, or no credentials when
+// authentication is enforced. Requests for public routes are never rejected.
+func (a *Authentication) enforce(public bool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		if public {
+			return next
+		}
+		return func(c echo.Context) error {
+			err, _ := c.Get(authErrorKey).(error)
+			if err == nil && PrincipalFrom(c.Request().Context()) == nil && a.Enforce {
+				err = ErrUnauthenticated
+			}
+			if err != nil {
+				Log(c.Request().Context()).Debugf("Rejecting %s %s: %v", c.Request().Method, c.Request().URL.Path, err)
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, "Bearer")
+				return NewProblemError(err, http.StatusUnauthorized, "")
+			}
+			return next(c)
+		}
+	}
+}
+
+// Public marks a route or group as public when passed as middleware to the EchoRouter of an engine: it can be called without
+// authentication, e.g.
+//  router.GET("/status", StatusOK, core.Public)
+// Routes are protected when not marked public. Public itself does nothing, the router records it when the route is registered.
+func Public(next echo.HandlerFunc) echo.HandlerFunc {
+	return next
+}
+
+// isPublic returns whether the middleware is Public
+func isPublic(m echo.MiddlewareFunc) bool {
+	return m != nil && reflect.ValueOf(m).Pointer() == reflect.ValueOf(Public).Pointer()
+}
@@ -0,0 +1,292 @@
+/*
+ * Nuts go core
+ * Copyright (C) 2020 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package core
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// authEngine has a protected route /protected and a public route /public, both responding with the principal name
+func authEngine() *Engine {
+	return &Engine{Name: "a", Routes: func(router EchoRouter) {
+		router.GET("/protected", principalName)
+		router.GET("/public", principalName, Public)
+	}}
+}
+
+func serveAuth(server *HTTPServer, path string, header string, value string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if header != "" {
+		req.Header.Set(header, value)
+	}
+	rec := httptest.NewRecorder()
+	server.Echo().ServeHTTP(rec, req)
+	return rec
+}
+
+func writeTokenFile(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "auth")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	file := filepath.Join(dir, "tokens")
+	if !assert.NoError(t, ioutil.WriteFile(file, []byte(content), 0600)) {
+		t.FailNow()
+	}
+	return file
+}
+
+func TestAuthentication_Middleware(t *testing.T) {
+	tokenFile := writeTokenFile(t, "# operators\nalice secret-1\n\nbob   secret-2\n")
+	defer os.RemoveAll(filepath.Dir(tokenFile))
+	enforced := NewNutsGlobalConfig()
+	enforced.v.Set(authEnforceFlag, true)
+	enforced.v.Set(authTokenFileFlag, tokenFile)
+	server := newTestServer(t, enforced, authEngine())
+
+	t.Run("bearer token", func(t *testing.T) {
+		rec := serveAuth(server, "/protected", echo.HeaderAuthorization, "Bearer secret-1")
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "alice", rec.Body.String())
+	})
+
+	t.Run("API key", func(t *testing.T) {
+		rec := serveAuth(server, "/protected", APIKeyHeader, "secret-2")
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "bob", rec.Body.String())
+	})
+
+	t.Run("missing credentials are rejected", func(t *testing.T) {
+		rec := serveAuth(server, "/protected", "", "")
+
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Equal(t, "Bearer", rec.Header().Get(echo.HeaderWWWAuthenticate))
+		assert.Equal(t, "authentication required", problemBody(t, rec)["detail"])
+	})
+
+	t.Run("unknown token is rejected", func(t *testing.T) {
+		rec := serveAuth(server, "/protected", echo.HeaderAuthorization, "Bearer secret-3")
+
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Equal(t, "invalid credentials: unknown token", problemBody(t, rec)["detail"])
+	})
+
+	t.Run("public route without credentials", func(t *testing.T) {
+		rec := serveAuth(server, "/public", echo.HeaderAuthorization, "Bearer secret-3")
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "anonymous", rec.Body.String())
+	})
+
+	t.Run("public route with credentials", func(t *testing.T) {
+		rec := serveAuth(server, "/public", APIKeyHeader, "secret-1")
+
+		assert.Equal(t, "alice", rec.Body.String())
+	})
+
+	t.Run("not enforced", func(t *testing.T) {
+		cfg := NewNutsGlobalConfig()
+		cfg.v.Set(authTokenFileFlag, tokenFile)
+		server := newTestServer(t, cfg, authEngine())
+
+		rec := serveAuth(server, "/protected", "", "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "anonymous", rec.Body.String())
+
+		rec = serveAuth(server, "/protected", APIKeyHeader, "unknown")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("scoped routes are protected", func(t *testing.T) {
+		ctl := NewEngineControl()
+		ctl.Register(&Engine{Name: "a", ConfigKey: "a", ScopedRoutes: true, Routes: func(router EchoRouter) {
+			router.POST("/b", func(c echo.Context) error {
+				return c.NoContent(http.StatusNoContent)
+			})
+		}})
+		server, _ := NewHTTPServer(ctl, enforced)
+		rec := httptest.NewRecorder()
+
+		server.Echo().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/a/b", nil))
+
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("enforced in strict mode", func(t *testing.T) {
+		cfg := NewNutsGlobalConfig()
+		cfg.v.Set(strictModeFlag, true)
+
+		assert.True(t, cfg.Auth().Enforce)
+	})
+}
+
+func TestNewAuthentication(t *testing.T) {
+	t.Run("error when token file can't be read", func(t *testing.T) {
+		_, err := NewAuthentication(AuthOptions{TokenFile: "non-existing"})
+
+		assert.True(t, errors.Is(err, ErrInvalidAuthConfig))
+	})
+
+	t.Run("error on invalid token file", func(t *testing.T) {
+		tokenFile := writeTokenFile(t, "alice secret-1\nbob\n")
+		defer os.RemoveAll(filepath.Dir(tokenFile))
+
+		_, err := NewAuthentication(AuthOptions{TokenFile: tokenFile})
+
+		assert.True(t, errors.Is(err, ErrInvalidAuthConfig))
+		assert.Contains(t, err.Error(), "line 2")
+	})
+
+	t.Run("server refuses to start when enforced without authenticators", func(t *testing.T) {
+		cfg := runnerConfig("localhost:0")
+		cfg.v.Set(authEnforceFlag, true)
+		server, _ := NewHTTPServer(NewEngineControl(), cfg)
+
+		err := server.Start()
+
+		assert.True(t, errors.Is(err, ErrInvalidAuthConfig))
+	})
+
+	t.Run("custom authenticator", func(t *testing.T) {
+		cfg := NewNutsGlobalConfig()
+		cfg.v.Set(authEnforceFlag, true)
+		server := newTestServer(t, cfg, authEngine())
+		server.Authentication().Add(NewTokenAuthenticator(map[string]string{"secret": "carol"}))
+
+		rec := serveAuth(server, "/protected", APIKeyHeader, "secret")
+
+		assert.Equal(t, "carol", rec.Body.String())
+	})
+}
+
+func TestClientCertAuthenticator_Authenticate(t *testing.T) {
+	t.Run("verified certificate", func(t *testing.T) {
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: "node-1"}}
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+
+		principal, err := ClientCertAuthenticator{}.Authenticate(req)
+
+		assert.NoError(t, err)
+		assert.Equal(t, &Principal{Name: "node-1", Method: ClientCertAuthMethod, Certificate: cert}, principal)
+	})
+
+	t.Run("unverified certificate", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{}}}
+
+		principal, err := ClientCertAuthenticator{}.Authenticate(req)
+
+		assert.NoError(t, err)
+		assert.Nil(t, principal)
+	})
+
+	t.Run("authenticated over TLS", func(t *testing.T) {
+		pki := newTestPKI(t)
+		defer os.RemoveAll(pki.dir)
+		certFile, keyFile := pki.issue(t, "server", 2)
+		clientCert, _ := tls.LoadX509KeyPair(pki.issue(t, "client", 3))
+		cfg := tlsServerConfig(certFile, keyFile)
+		cfg.v.Set(tlsCAFileFlag, pki.caFile)
+		cfg.v.Set(tlsClientAuthFlag, "verifyifgiven")
+		cfg.v.Set(authClientCertFlag, true)
+		cfg.v.Set(authEnforceFlag, true)
+		server := newTestServer(t, cfg, authEngine())
+		if !assert.NoError(t, server.Start()) {
+			return
+		}
+		defer server.Shutdown(context.Background())
+		url := fmt.Sprintf("https://%s/protected", server.Addr())
+
+		resp, err := pki.tlsClient(&clientCert).Get(url)
+		if assert.NoError(t, err) {
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			assert.Equal(t, "client", string(body))
+		}
+
+		resp, err = pki.tlsClient(nil).Get(url)
+		if assert.NoError(t, err) {
+			resp.Body.Close()
+			assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		}
+	})
+}
+
+func TestSocketAuthenticator_Authenticate(t *testing.T) {
+	t.Run("unix domain socket", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req = req.WithContext(context.WithValue(req.Context(), http.LocalAddrContextKey, &net.UnixAddr{Name: "nuts.sock", Net: "unix"}))
+
+		principal, err := SocketAuthenticator{}.Authenticate(req)
+
+		assert.NoError(t, err)
+		assert.Equal(t, &Principal{Name: SocketPrincipalName, Method: SocketAuthMethod}, principal)
+	})
+
+	t.Run("TCP", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req = req.WithContext(context.WithValue(req.Context(), http.LocalAddrContextKey, &net.TCPAddr{}))
+
+		principal, err := SocketAuthenticator{}.Authenticate(req)
+
+		assert.NoError(t, err)
+		assert.Nil(t, principal)
+	})
+}
+
+func TestAuthOptions_validate(t *testing.T) {
+	tlsOptions := TLSOptions{CertFile: "c", KeyFile: "k", ClientAuth: "verify", MinVersion: "1.2"}
+
+	t.Run("ok", func(t *testing.T) {
+		assert.NoError(t, AuthOptions{ClientCert: true}.validate(tlsOptions))
+	})
+
+	t.Run("client certificates require TLS", func(t *testing.T) {
+		err := AuthOptions{ClientCert: true}.validate(TLSOptions{})
+
+		assert.True(t, errors.Is(err, ErrInvalidAuthConfig))
+	})
+
+	t.Run("client certificates must be verified", func(t *testing.T) {
+		tlsOptions.ClientAuth = "require"
+
+		err := AuthOptions{ClientCert: true}.validate(tlsOptions)
+
+		assert.True(t, errors.Is(err, ErrInvalidAuthConfig))
+	})
+}
@@ -27,6 +27,7 @@ import (
 	"reflect"
 	"strings"
 	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -40,14 +41,24 @@ const defaultConfigFile = "nuts.yaml"
 const configFileFlag = "configfile"
 const loggerLevelFlag = "verbosity"
 const addressFlag = "address"
+const adminAddressFlag = "adminaddress"
 const defaultLogLevel = "info"
 const defaultAddress = "localhost:1323"
 const strictModeFlag = "strictmode"
 const modeFlag = "mode"
 const identityFlag = "identity"
+const shutdownTimeoutFlag = "shutdowntimeout"
+const defaultShutdownTimeout = 10 * time.Second
+const enabledEnginesFlag = "enabledengines"
+const disabledEnginesFlag = "disabledengines"
 
 var defaultIgnoredPrefixes = []string{"root"}
 
+// globalFlags holds the names of the flags defined by Load, engines can't use these
+var globalFlags = []string{configFileFlag, loggerLevelFlag, addressFlag, adminAddressFlag, strictModeFlag, modeFlag, identityFlag, shutdownTimeoutFlag, enabledEnginesFlag, disabledEnginesFlag,
+	tlsCertFileFlag, tlsKeyFileFlag, tlsCAFileFlag, tlsClientAuthFlag, tlsMinVersionFlag, authEnforceFlag, authTokenFileFlag, authClientCertFlag,
+	httpRateLimitFlag, httpRateLimitBurstFlag, httpMaxBodySizeFlag, httpReadTimeoutFlag, httpWriteTimeoutFlag}
+
 // Make sure NutsGlobalConfig implements NutConfigValues interface
 var _ NutsConfigValues = (*NutsGlobalConfig)(nil)
 
@@ -65,12 +76,28 @@ type NutsGlobalConfig struct {
 	// IgnoredPrefixes is a slice of prefixes which will not be used to prepend config variables, eg: --logging.verbosity will just be --verbosity
 	IgnoredPrefixes []string
 
-	v *viper.Viper
+	v     *viper.Viper
+	state *configState
+}
+
+// configState guards the config of a NutsGlobalConfig against concurrent reloads and holds the values a reload didn't apply,
+// see NutsGlobalConfig.Reload
+type configState struct {
+	// values guards reading the values of the viper instance and pinned against a reload replacing them
+	values sync.RWMutex
+	// reload makes sure reloads triggered by the file watcher, signals and the admin endpoint don't run concurrently
+	reload sync.Mutex
+	// pinned holds the previous values of the keys changed in the config file that weren't applied, because they require
+	// a restart or were rejected by an engine. pins holds the same values, to read them with the conversions of viper.
+	pinned map[string]interface{}
+	pins   *viper.Viper
 }
 
 // NutsConfigValues exposes global configuration values
 type NutsConfigValues interface {
 	ServerAddress() string
+	// AdminAddress returns the address the administrative routes are served on, empty when they're served on ServerAddress
+	AdminAddress() string
 	InStrictMode() bool
 	Mode() string
 	// Identity returns the current vendor node's identity.
@@ -80,6 +107,20 @@ type NutsConfigValues interface {
 	// VendorID returns the current node's identity
 	VendorID() PartyID
 	GetEngineMode(engineMode string) string
+	// ShutdownTimeout returns the default time an engine may take to shut down
+	ShutdownTimeout() time.Duration
+	// EnabledEngines returns the ConfigKeys of the engines to run, empty means all engines
+	EnabledEngines() []string
+	// DisabledEngines returns the ConfigKeys of the engines not to run
+	DisabledEngines() []string
+	// TLS returns the TLS options of the node's HTTP server
+	TLS() TLSOptions
+	// Auth returns the authentication options of the node's HTTP server
+	Auth() AuthOptions
+	// Limits returns the limits of the routes of the engine with the given ConfigKey, the global limits when empty
+	Limits(configKey string) LimitOptions
+	// Timeouts returns the timeouts of the connections to the node's HTTP server
+	Timeouts() TimeoutOptions
 }
 
 const (
@@ -108,13 +149,15 @@ func NewNutsGlobalConfig() *NutsGlobalConfig {
 		Delimiter:         defaultSeparator,
 		IgnoredPrefixes:   defaultIgnoredPrefixes,
 		v:                 viper.New(),
+		state:             &configState{},
 	}
 }
 
 var configOnce sync.Once
 var configInstance *NutsGlobalConfig
 
-// NutsGlobalConfig returns a singleton global config
+// NutsConfig returns a singleton global config. It's a convenience for executables running a single node,
+// use NewNutsGlobalConfig to create independent instances.
 func NutsConfig() *NutsGlobalConfig {
 	configOnce.Do(func() {
 		configInstance = NewNutsGlobalConfig()
@@ -123,18 +166,208 @@ func NutsConfig() *NutsGlobalConfig {
 }
 
 // ServerAddress is the address which is used to either listen on (in server mode) or connect to (in client mode).
+// It's a host and port or a unix domain socket, see UnixSocketScheme and NodeClient.
 func (ngc NutsGlobalConfig) ServerAddress() string {
-	return ngc.v.GetString(addressFlag)
+	return ngc.getString(addressFlag)
+}
+
+// AdminAddress is the address the routes of engines with AdminRoutes are served on, instead of on ServerAddress.
+// It's empty when no separate admin listener is configured.
+func (ngc NutsGlobalConfig) AdminAddress() string {
+	return ngc.getString(adminAddressFlag)
 }
 
 // InStrictMode helps to safeguard settings which are handy and default in development but not safe for production.
 func (ngc NutsGlobalConfig) InStrictMode() bool {
-	return ngc.v.GetBool(strictModeFlag)
+	return ngc.getBool(strictModeFlag)
 }
 
 // Mode returns the configured mode (client/server).
 func (ngc NutsGlobalConfig) Mode() string {
-	return ngc.v.GetString(modeFlag)
+	return ngc.getString(modeFlag)
+}
+
+// ShutdownTimeout returns the default time an engine may take to shut down, engines may override it.
+func (ngc NutsGlobalConfig) ShutdownTimeout() time.Duration {
+	return ngc.getDuration(shutdownTimeoutFlag)
+}
+
+// EnabledEngines returns the ConfigKeys of the engines to run. When empty, all engines run except the disabled ones.
+func (ngc NutsGlobalConfig) EnabledEngines() []string {
+	return ngc.getList(enabledEnginesFlag)
+}
+
+// DisabledEngines returns the ConfigKeys of the engines not to run.
+func (ngc NutsGlobalConfig) DisabledEngines() []string {
+	return ngc.getList(disabledEnginesFlag)
+}
+
+// TLS returns the TLS options of the node's HTTP server.
+func (ngc NutsGlobalConfig) TLS() TLSOptions {
+	return TLSOptions{
+		CertFile:   ngc.getString(tlsCertFileFlag),
+		KeyFile:    ngc.getString(tlsKeyFileFlag),
+		CAFile:     ngc.getString(tlsCAFileFlag),
+		ClientAuth: ngc.getString(tlsClientAuthFlag),
+		MinVersion: ngc.getString(tlsMinVersionFlag),
+	}
+}
+
+// Auth returns the authentication options of the node's HTTP server. Authentication is always enforced in strict mode.
+func (ngc NutsGlobalConfig) Auth() AuthOptions {
+	return AuthOptions{
+		Enforce:    ngc.getBool(authEnforceFlag) || ngc.InStrictMode(),
+		TokenFile:  ngc.getString(authTokenFileFlag),
+		ClientCert: ngc.getBool(authClientCertFlag),
+	}
+}
+
+// Limits returns the limits of the requests for the routes of the engine with the given ConfigKey: the global limits,
+// overridden by the limits configured for the engine under http.engines.<ConfigKey>. It returns the global limits when configKey is empty.
+func (ngc NutsGlobalConfig) Limits(configKey string) LimitOptions {
+	get := func(flag string) string {
+		if configKey != "" {
+			if key := httpEngineLimitsKey + "." + configKey + strings.TrimPrefix(flag, "http"); ngc.isSet(key) {
+				return key
+			}
+		}
+		return flag
+	}
+	return LimitOptions{
+		RateLimit:      ngc.getFloat64(get(httpRateLimitFlag)),
+		RateLimitBurst: ngc.getInt(get(httpRateLimitBurstFlag)),
+		MaxBodySize:    ngc.getInt64(get(httpMaxBodySizeFlag)),
+	}
+}
+
+// Timeouts returns the timeouts of the connections to the node's HTTP server.
+func (ngc NutsGlobalConfig) Timeouts() TimeoutOptions {
+	return TimeoutOptions{
+		Read:  ngc.getDuration(httpReadTimeoutFlag),
+		Write: ngc.getDuration(httpWriteTimeoutFlag),
+	}
+}
+
+// EngineEnabled returns whether the engine with the given ConfigKey is enabled, see EnabledEngines and DisabledEngines.
+// Engines without ConfigKey are always enabled.
+func (ngc NutsGlobalConfig) EngineEnabled(configKey string) bool {
+	return engineEnabled(ngc, configKey)
+}
+
+func engineEnabled(config NutsConfigValues, configKey string) bool {
+	if configKey == "" {
+		return true
+	}
+	if contains(config.DisabledEngines(), configKey) {
+		return false
+	}
+	enabled := config.EnabledEngines()
+	return len(enabled) == 0 || contains(enabled, configKey)
+}
+
+// getList returns the values of a string slice option, values given as a single comma separated string (e.g. from an ENV variable) are split
+func (ngc NutsGlobalConfig) getList(key string) []string {
+	var result []string
+	for _, value := range ngc.getStringSlice(key) {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				result = append(result, item)
+			}
+		}
+	}
+	return result
+}
+
+// The functions below read the values of the viper instance, guarded against a concurrent reload. Keys of which Reload didn't
+// apply the changed value return their previous value. They lock for a single value only, so they can be called while holding
+// no other config lock.
+
+// source returns the viper instance holding the value of the key, the caller must hold the values lock
+func (ngc NutsGlobalConfig) source(key string) *viper.Viper {
+	if _, ok := ngc.state.pinned[strings.ToLower(key)]; ok {
+		return ngc.state.pins
+	}
+	return ngc.v
+}
+
+func (ngc NutsGlobalConfig) get(key string) interface{} {
+	ngc.state.values.RLock()
+	defer ngc.state.values.RUnlock()
+	return ngc.source(key).Get(key)
+}
+
+func (ngc NutsGlobalConfig) getString(key string) string {
+	ngc.state.values.RLock()
+	defer ngc.state.values.RUnlock()
+	return ngc.source(key).GetString(key)
+}
+
+func (ngc NutsGlobalConfig) getBool(key string) bool {
+	ngc.state.values.RLock()
+	defer ngc.state.values.RUnlock()
+	return ngc.source(key).GetBool(key)
+}
+
+func (ngc NutsGlobalConfig) getDuration(key string) time.Duration {
+	ngc.state.values.RLock()
+	defer ngc.state.values.RUnlock()
+	return ngc.source(key).GetDuration(key)
+}
+
+func (ngc NutsGlobalConfig) getFloat64(key string) float64 {
+	ngc.state.values.RLock()
+	defer ngc.state.values.RUnlock()
+	return ngc.source(key).GetFloat64(key)
+}
+
+func (ngc NutsGlobalConfig) getInt(key string) int {
+	ngc.state.values.RLock()
+	defer ngc.state.values.RUnlock()
+	return ngc.source(key).GetInt(key)
+}
+
+func (ngc NutsGlobalConfig) getInt32(key string) int32 {
+	ngc.state.values.RLock()
+	defer ngc.state.values.RUnlock()
+	return ngc.source(key).GetInt32(key)
+}
+
+func (ngc NutsGlobalConfig) getInt64(key string) int64 {
+	ngc.state.values.RLock()
+	defer ngc.state.values.RUnlock()
+	return ngc.source(key).GetInt64(key)
+}
+
+func (ngc NutsGlobalConfig) getStringSlice(key string) []string {
+	ngc.state.values.RLock()
+	defer ngc.state.values.RUnlock()
+	return ngc.source(key).GetStringSlice(key)
+}
+
+func (ngc NutsGlobalConfig) isSet(key string) bool {
+	ngc.state.values.RLock()
+	defer ngc.state.values.RUnlock()
+	if value, ok := ngc.state.pinned[strings.ToLower(key)]; ok {
+		return value != nil
+	}
+	return ngc.v.IsSet(key)
+}
+
+func (ngc NutsGlobalConfig) allKeys() []string {
+	ngc.state.values.RLock()
+	defer ngc.state.values.RUnlock()
+	var keys []string
+	for _, key := range ngc.v.AllKeys() {
+		if _, ok := ngc.state.pinned[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	for key, value := range ngc.state.pinned {
+		if value != nil {
+			keys = append(keys, key)
+		}
+	}
+	return keys
 }
 
 // Identity returns the current vendor's identity. This is a mandatory parameter which must be in the following form:
@@ -156,7 +389,7 @@ func (ngc NutsGlobalConfig) VendorID() PartyID {
 }
 
 func (ngc NutsGlobalConfig) tryGetVendorID() (PartyID, error) {
-	identity := ngc.v.GetString(identityFlag)
+	identity := ngc.getString(identityFlag)
 	if strings.TrimSpace(identity) == "" {
 		return PartyID{}, nil
 	}
@@ -187,10 +420,27 @@ func (ngc *NutsGlobalConfig) Load(cmd *cobra.Command) error {
 	flagSet := pflag.NewFlagSet("config", pflag.ContinueOnError)
 	flagSet.String(configFileFlag, ngc.DefaultConfigFile, "Nuts config file")
 	flagSet.String(loggerLevelFlag, defaultLogLevel, "Log level (trace, debug, info, warn, error)")
-	flagSet.String(addressFlag, defaultAddress, "Address and port the server will be listening to")
+	flagSet.String(addressFlag, defaultAddress, "Address and port the server will be listening to, or "+UnixSocketScheme+"<path> for a unix domain socket. In CLI mode, the address of the node.")
+	flagSet.String(adminAddressFlag, "", "Address and port the status, metrics and other administrative routes are served on, or "+UnixSocketScheme+"<path> for a unix domain socket. When not set, they're served on "+addressFlag+".")
 	flagSet.Bool(strictModeFlag, false, "When set, insecure settings are forbidden.")
 	flagSet.String(modeFlag, "server", "Mode the application will run in. When 'cli' it can be used to administer a remote Nuts node. When 'server' it will start a Nuts node. Defaults to 'server'.")
 	flagSet.String(identityFlag, "", "Vendor identity for the node, mandatory when running in server mode. Must be in the format: urn:oid:"+NutsVendorOID+":<number>")
+	flagSet.Duration(shutdownTimeoutFlag, defaultShutdownTimeout, "Maximum time an engine may take to shut down, unless overridden by the engine. 0 means no limit.")
+	flagSet.StringSlice(enabledEnginesFlag, nil, "ConfigKeys of the engines to run, when not set all engines run (except the disabled ones).")
+	flagSet.StringSlice(disabledEnginesFlag, nil, "ConfigKeys of the engines not to run.")
+	flagSet.String(tlsCertFileFlag, "", "PEM file containing the server certificate (chain), when set the server is served over TLS.")
+	flagSet.String(tlsKeyFileFlag, "", "PEM file containing the private key of the server certificate.")
+	flagSet.String(tlsCAFileFlag, "", "PEM file containing the CA certificates client certificates are verified against.")
+	flagSet.String(tlsClientAuthFlag, defaultTLSClientAuth, "Client certificate policy: none, request, require, verifyifgiven or verify.")
+	flagSet.String(tlsMinVersionFlag, defaultTLSMinVersion, "Minimum TLS version: 1.0, 1.1, 1.2 or 1.3.")
+	flagSet.Bool(authEnforceFlag, false, "When set, requests for routes not marked public must be authenticated. Always set in strict mode.")
+	flagSet.String(authTokenFileFlag, "", "File containing the accepted API keys and bearer tokens, one '<principal> <token>' per line.")
+	flagSet.Float64(httpRateLimitFlag, 0, "Maximum number of requests per second per client (principal or IP address), 0 means unlimited.")
+	flagSet.Int(httpRateLimitBurstFlag, 0, "Number of requests a client may send at once, defaults to the rate limit rounded up.")
+	flagSet.Int64(httpMaxBodySizeFlag, 0, "Maximum size of a request body in bytes, 0 means unlimited.")
+	flagSet.Duration(httpReadTimeoutFlag, 0, "Maximum duration for reading a request, including its body. 0 means no limit.")
+	flagSet.Duration(httpWriteTimeoutFlag, 0, "Maximum duration for writing a response. 0 means no limit.")
+	flagSet.Bool(authClientCertFlag, false, "When set, requests are authenticated by their client certificate, requires "+tlsClientAuthFlag+" verifyifgiven or verify.")
 	cmd.PersistentFlags().AddFlagSet(flagSet)
 
 	// Bind config flag
@@ -198,9 +448,26 @@ func (ngc *NutsGlobalConfig) Load(cmd *cobra.Command) error {
 	ngc.bindFlag(flagSet, configFileFlag)
 	ngc.bindFlag(flagSet, loggerLevelFlag)
 	ngc.bindFlag(flagSet, addressFlag)
+	ngc.bindFlag(flagSet, adminAddressFlag)
 	ngc.bindFlag(flagSet, strictModeFlag)
 	ngc.bindFlag(flagSet, modeFlag)
 	ngc.bindFlag(flagSet, identityFlag)
+	ngc.bindFlag(flagSet, shutdownTimeoutFlag)
+	ngc.bindFlag(flagSet, enabledEnginesFlag)
+	ngc.bindFlag(flagSet, disabledEnginesFlag)
+	ngc.bindFlag(flagSet, tlsCertFileFlag)
+	ngc.bindFlag(flagSet, tlsKeyFileFlag)
+	ngc.bindFlag(flagSet, tlsCAFileFlag)
+	ngc.bindFlag(flagSet, tlsClientAuthFlag)
+	ngc.bindFlag(flagSet, tlsMinVersionFlag)
+	ngc.bindFlag(flagSet, authEnforceFlag)
+	ngc.bindFlag(flagSet, authTokenFileFlag)
+	ngc.bindFlag(flagSet, authClientCertFlag)
+	ngc.bindFlag(flagSet, httpRateLimitFlag)
+	ngc.bindFlag(flagSet, httpRateLimitBurstFlag)
+	ngc.bindFlag(flagSet, httpMaxBodySizeFlag)
+	ngc.bindFlag(flagSet, httpReadTimeoutFlag)
+	ngc.bindFlag(flagSet, httpWriteTimeoutFlag)
 
 	// load flags into viper
 	pfs := cmd.PersistentFlags()
@@ -227,8 +494,14 @@ func (ngc *NutsGlobalConfig) Load(cmd *cobra.Command) error {
 		return fmt.Errorf("unsupported global mode: %s, supported modes: %s", ngc.Mode(), strings.Join([]string{GlobalCLIMode, GlobalServerMode}, ", "))
 	}
 
-	// Validate identity
+	// Validate identity, TLS and authentication options
 	if ngc.Mode() == GlobalServerMode {
+		if err := ngc.TLS().validate(); err != nil {
+			return err
+		}
+		if err := ngc.Auth().validate(ngc.TLS()); err != nil {
+			return err
+		}
 		vendorID, err := ngc.tryGetVendorID()
 		if err != nil {
 			return fmt.Errorf("identity is invalid: %w", err)
@@ -255,15 +528,20 @@ func (ngc *NutsGlobalConfig) bindFlag(fs *pflag.FlagSet, name string) error {
 	return nil
 }
 
-// PrintConfig outputs the current config to the logger on info level
+// PrintConfig outputs the current config of the global config and the engines registered in EngineCtl to the logger on info level
 func (ngc *NutsGlobalConfig) PrintConfig(logger log.FieldLogger) {
+	ngc.PrintConfigFor(logger, &EngineCtl)
+}
+
+// PrintConfigFor outputs the current config of the global config and the engines registered in the given EngineControl to the logger on info level
+func (ngc *NutsGlobalConfig) PrintConfigFor(logger log.FieldLogger, engines *EngineControl) {
 	title := "Config"
 	var longestKey = 10
 	var longestValue int
-	for _, e := range EngineCtl.Engines {
-		if e.FlagSet != nil {
+	for _, e := range engines.All() {
+		if e.FlagSet != nil && ngc.EngineEnabled(e.ConfigKey) {
 			e.FlagSet.VisitAll(func(flag *pflag.Flag) {
-				s := fmt.Sprintf("%v", ngc.v.Get(strings.ToLower(flag.Name)))
+				s := fmt.Sprintf("%v", ngc.get(strings.ToLower(flag.Name)))
 				if len(s) > longestValue {
 					longestValue = len(s)
 				}
@@ -286,14 +564,35 @@ func (ngc *NutsGlobalConfig) PrintConfig(logger log.FieldLogger) {
 
 	logger.Infof(f, identityFlag, ngc.Identity())
 	logger.Infof(f, addressFlag, ngc.ServerAddress())
-	logger.Infof(f, configFileFlag, ngc.v.Get(configFileFlag))
-	logger.Infof(f, loggerLevelFlag, ngc.v.Get(loggerLevelFlag))
+	logger.Infof(f, adminAddressFlag, ngc.AdminAddress())
+	logger.Infof(f, configFileFlag, ngc.get(configFileFlag))
+	logger.Infof(f, loggerLevelFlag, ngc.get(loggerLevelFlag))
 	logger.Infof(f, strictModeFlag, ngc.InStrictMode())
 	logger.Infof(f, modeFlag, ngc.Mode())
-	for _, e := range EngineCtl.Engines {
-		if e.FlagSet != nil {
+	logger.Infof(f, shutdownTimeoutFlag, ngc.ShutdownTimeout())
+	logger.Infof(f, enabledEnginesFlag, ngc.EnabledEngines())
+	logger.Infof(f, disabledEnginesFlag, ngc.DisabledEngines())
+	tlsOptions := ngc.TLS()
+	logger.Infof(f, tlsCertFileFlag, tlsOptions.CertFile)
+	logger.Infof(f, tlsKeyFileFlag, tlsOptions.KeyFile)
+	logger.Infof(f, tlsCAFileFlag, tlsOptions.CAFile)
+	logger.Infof(f, tlsClientAuthFlag, tlsOptions.ClientAuth)
+	logger.Infof(f, tlsMinVersionFlag, tlsOptions.MinVersion)
+	authOptions := ngc.Auth()
+	logger.Infof(f, authEnforceFlag, authOptions.Enforce)
+	logger.Infof(f, authTokenFileFlag, authOptions.TokenFile)
+	logger.Infof(f, authClientCertFlag, authOptions.ClientCert)
+	limits := ngc.Limits("")
+	logger.Infof(f, httpRateLimitFlag, limits.RateLimit)
+	logger.Infof(f, httpRateLimitBurstFlag, limits.RateLimitBurst)
+	logger.Infof(f, httpMaxBodySizeFlag, limits.MaxBodySize)
+	timeouts := ngc.Timeouts()
+	logger.Infof(f, httpReadTimeoutFlag, timeouts.Read)
+	logger.Infof(f, httpWriteTimeoutFlag, timeouts.Write)
+	for _, e := range engines.All() {
+		if e.FlagSet != nil && ngc.EngineEnabled(e.ConfigKey) {
 			e.FlagSet.VisitAll(func(flag *pflag.Flag) {
-				logger.Infof(f, flag.Name, ngc.v.Get(strings.ToLower(flag.Name)))
+				logger.Infof(f, flag.Name, ngc.get(strings.ToLower(flag.Name)))
 			})
 		}
 	}
@@ -323,7 +622,7 @@ func (ngc *NutsGlobalConfig) loadConfigFile() error {
 }
 
 // InjectIntoEngine loop over all flags from an engine and injects any value into the given Config struct for the Engine.
-// If the Engine does not have a config struct, it does nothing.
+// If the Engine does not have a config struct or is disabled (see EngineEnabled), it does nothing.
 // Any config not registered as global flag will be ignored.
 // It expects all config var names to be prepended or nested with the Engine ConfigKey,
 // this will be ignored if the ConfigKey is "" or if the key is in the set of ignored prefixes.
@@ -331,7 +630,7 @@ func (ngc *NutsGlobalConfig) InjectIntoEngine(e *Engine) error {
 	var err error
 
 	// ignore if no target for injection
-	if e.Config != nil {
+	if e.Config != nil && ngc.EngineEnabled(e.ConfigKey) {
 		// ignore if no registered flags
 		if e.FlagSet != nil {
 			fs := e.FlagSet
@@ -352,7 +651,7 @@ func (ngc *NutsGlobalConfig) InjectIntoEngine(e *Engine) error {
 
 				// test if is set, this can not be done with IsSet, because it doesn't take ENV variables into account.
 				var val interface{}
-				val = ngc.v.Get(configName)
+				val = ngc.get(configName)
 				if val == nil {
 					err = fmt.Errorf("nil value for %v, forgot to add flag binding", configName)
 					return
@@ -363,23 +662,23 @@ func (ngc *NutsGlobalConfig) InjectIntoEngine(e *Engine) error {
 				// get real value with correct type
 				switch field.Kind() {
 				case reflect.Int:
-					val = ngc.v.GetInt(configName)
+					val = ngc.getInt(configName)
 				case reflect.Int32:
-					val = ngc.v.GetInt32(configName)
+					val = ngc.getInt32(configName)
 				case reflect.Int64:
-					val = ngc.v.GetInt64(configName)
+					val = ngc.getInt64(configName)
 				case reflect.String:
-					val = ngc.v.GetString(configName)
+					val = ngc.getString(configName)
 				case reflect.Bool:
-					val = ngc.v.GetBool(configName)
+					val = ngc.getBool(configName)
 				case reflect.Slice:
-					val = ngc.v.Get(configName)
+					val = ngc.get(configName)
 					valI := val.([]interface{})
 					if _, ok := valI[0].(string); ok {
 						isStringSlice = true
 					}
 				default:
-					val = ngc.v.Get(configName)
+					val = ngc.get(configName)
 				}
 
 				if val == nil {
@@ -409,9 +708,9 @@ func (ngc *NutsGlobalConfig) InjectIntoEngine(e *Engine) error {
 func (ngc *NutsGlobalConfig) injectIntoStruct(s interface{}) error {
 	var err error
 
-	for _, configName := range ngc.v.AllKeys() {
-		// ignore global flags
-		if configName == configFileFlag || configName == loggerLevelFlag || configName == addressFlag || configName == strictModeFlag || configName == modeFlag {
+	for _, configName := range ngc.allKeys() {
+		// ignore global flags, except for identity which is used by the target as well
+		if isGlobalFlag(configName) && configName != identityFlag {
 			continue
 		}
 
@@ -424,15 +723,16 @@ func (ngc *NutsGlobalConfig) injectIntoStruct(s interface{}) error {
 		}
 
 		// inject value
-		field.Set(reflect.ValueOf(ngc.v.Get(configName)))
+		field.Set(reflect.ValueOf(ngc.get(configName)))
 	}
 	return err
 }
 
 // RegisterFlags adds the flagSet of an engine to the commandline, flag names are prefixed if needed
-// The passed command must be the root command not the engine.Cmd (unless they are the same)
+// The passed command must be the root command not the engine.Cmd (unless they are the same).
+// Flags of disabled engines (see EngineEnabled) are not added.
 func (ngc *NutsGlobalConfig) RegisterFlags(cmd *cobra.Command, e *Engine) {
-	if e.FlagSet != nil {
+	if e.FlagSet != nil && ngc.EngineEnabled(e.ConfigKey) {
 		fs := e.FlagSet
 
 		fs.VisitAll(func(f *pflag.Flag) {
@@ -457,6 +757,19 @@ func (ngc *NutsGlobalConfig) RegisterFlags(cmd *cobra.Command, e *Engine) {
 	}
 }
 
+func isGlobalFlag(name string) bool {
+	return contains(globalFlags, name)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
 func (ngc *NutsGlobalConfig) isIgnoredPrefix(prefix string) bool {
 	for _, ip := range ngc.IgnoredPrefixes {
 		if ip == prefix {
@@ -21,10 +21,12 @@ package core
 
 import (
 	"bytes"
+	"errors"
 	"os"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -92,6 +94,20 @@ func TestNutsGlobalConfig_Load(t *testing.T) {
 		}
 	})
 
+	t.Run("Invalid TLS options", func(t *testing.T) {
+		os.Setenv("NUTS_TLS_CERTFILE", "cert.pem")
+		defer os.Unsetenv("NUTS_TLS_CERTFILE")
+		err := NewNutsGlobalConfig().Load(&cobra.Command{})
+		assert.True(t, errors.Is(err, ErrInvalidTLSConfig))
+	})
+
+	t.Run("Invalid authentication options", func(t *testing.T) {
+		os.Setenv("NUTS_AUTH_CLIENTCERT", "true")
+		defer os.Unsetenv("NUTS_AUTH_CLIENTCERT")
+		err := NewNutsGlobalConfig().Load(&cobra.Command{})
+		assert.True(t, errors.Is(err, ErrInvalidAuthConfig))
+	})
+
 	t.Run("Identity not configured", func(t *testing.T) {
 		os.Unsetenv("NUTS_IDENTITY")
 		err := cfg.Load(&cobra.Command{})
@@ -174,6 +190,22 @@ func TestNutsGlobalConfig_Load2(t *testing.T) {
 		assert.True(t, cfg.InStrictMode())
 	})
 
+	t.Run("Shutdown timeout has a default", func(t *testing.T) {
+		os.Args = []string{"command"}
+		cfg := NewNutsGlobalConfig()
+		err := cfg.Load(&cobra.Command{})
+		assert.NoError(t, err)
+		assert.Equal(t, defaultShutdownTimeout, cfg.ShutdownTimeout())
+	})
+
+	t.Run("Shutdown timeout can be configured", func(t *testing.T) {
+		os.Args = []string{"command", "--shutdowntimeout", "25s"}
+		cfg := NewNutsGlobalConfig()
+		err := cfg.Load(&cobra.Command{})
+		assert.NoError(t, err)
+		assert.Equal(t, 25*time.Second, cfg.ShutdownTimeout())
+	})
+
 	t.Run("Unsupported mode", func(t *testing.T) {
 		os.Setenv("NUTS_MODE", "foobar")
 		defer func() {
@@ -188,17 +220,14 @@ func TestNutsGlobalConfig_PrintConfig(t *testing.T) {
 	cfg := NewNutsGlobalConfig()
 	fs := pflag.FlagSet{}
 	fs.String("camelCaseKey", "value", "description")
-	EngineCtl.registerEngine(&Engine{FlagSet: &fs})
+	ctl := NewEngineControl()
+	ctl.Register(&Engine{FlagSet: &fs})
 	logger := logrus.New()
 	buf := new(bytes.Buffer)
 	logger.Out = buf
-	cfg.PrintConfig(logger)
+	cfg.PrintConfigFor(logger, ctl)
 	bs := buf.String()
 
-	defer func() {
-		EngineCtl.Engines = EngineCtl.Engines[:len(EngineCtl.Engines)-1]
-	}()
-
 	t.Run("output contains key", func(t *testing.T) {
 		if strings.Index(bs, "camelCaseKey") == -1 {
 			t.Error("Expected key to be in output")
@@ -265,6 +294,73 @@ func TestNutsGlobalConfig_RegisterFlags(t *testing.T) {
 			t.Errorf("Expected [key] to be available as config")
 		}
 	})
+
+	t.Run("does not add flags of disabled engine", func(t *testing.T) {
+		e := &Engine{
+			Cmd:       &cobra.Command{},
+			ConfigKey: "pre",
+			FlagSet:   pflag.NewFlagSet("dummy", pflag.ContinueOnError),
+		}
+		e.FlagSet.String("key", "", "")
+
+		cfg := NewNutsGlobalConfig()
+		cfg.v.Set(disabledEnginesFlag, []string{"pre"})
+		cfg.RegisterFlags(e.Cmd, e)
+
+		assert.Nil(t, e.Cmd.PersistentFlags().Lookup("pre.key"))
+	})
+}
+
+func TestNutsGlobalConfig_EngineEnabled(t *testing.T) {
+	t.Run("all engines are enabled by default", func(t *testing.T) {
+		cfg := NewNutsGlobalConfig()
+
+		assert.True(t, cfg.EngineEnabled("a"))
+	})
+
+	t.Run("disabled engines", func(t *testing.T) {
+		cfg := NewNutsGlobalConfig()
+		cfg.v.Set(disabledEnginesFlag, []string{"a"})
+
+		assert.False(t, cfg.EngineEnabled("a"))
+		assert.True(t, cfg.EngineEnabled("b"))
+	})
+
+	t.Run("enabled engines", func(t *testing.T) {
+		cfg := NewNutsGlobalConfig()
+		cfg.v.Set(enabledEnginesFlag, []string{"a", "b"})
+		cfg.v.Set(disabledEnginesFlag, []string{"b"})
+
+		assert.True(t, cfg.EngineEnabled("a"))
+		assert.False(t, cfg.EngineEnabled("b"))
+		assert.False(t, cfg.EngineEnabled("c"))
+	})
+
+	t.Run("engines without ConfigKey are always enabled", func(t *testing.T) {
+		cfg := NewNutsGlobalConfig()
+		cfg.v.Set(enabledEnginesFlag, []string{"a"})
+
+		assert.True(t, cfg.EngineEnabled(""))
+	})
+
+	t.Run("comma separated values from ENV", func(t *testing.T) {
+		os.Args = []string{"command"}
+		os.Setenv("NUTS_DISABLEDENGINES", "a, b")
+		defer os.Unsetenv("NUTS_DISABLEDENGINES")
+		cfg := NewNutsGlobalConfig()
+		_ = cfg.Load(&cobra.Command{})
+
+		assert.Equal(t, []string{"a", "b"}, cfg.DisabledEngines())
+	})
+
+	t.Run("from command line", func(t *testing.T) {
+		os.Args = []string{"command", "--enabledengines", "a,b"}
+		cfg := NewNutsGlobalConfig()
+		_ = cfg.Load(&cobra.Command{})
+
+		assert.Equal(t, []string{"a", "b"}, cfg.EnabledEngines())
+		assert.Empty(t, cfg.DisabledEngines())
+	})
 }
 
 func TestNutsGlobalConfig_LoadConfigFile(t *testing.T) {
@@ -272,6 +368,7 @@ func TestNutsGlobalConfig_LoadConfigFile(t *testing.T) {
 		cfg := NutsGlobalConfig{
 			DefaultConfigFile: "non_existing.yaml",
 			v:                 viper.New(),
+			state:             &configState{},
 		}
 		cfg.Load(&cobra.Command{})
 
@@ -284,6 +381,7 @@ func TestNutsGlobalConfig_LoadConfigFile(t *testing.T) {
 		cfg := NutsGlobalConfig{
 			DefaultConfigFile: "test/config/corrupt.yaml",
 			v:                 viper.New(),
+			state:             &configState{},
 		}
 		cfg.Load(&cobra.Command{})
 
@@ -303,6 +401,7 @@ func TestNutsGlobalConfig_LoadConfigFile(t *testing.T) {
 		cfg := NutsGlobalConfig{
 			DefaultConfigFile: "test/config/dummy.yaml",
 			v:                 viper.New(),
+			state:             &configState{},
 		}
 		cfg.Load(&cobra.Command{})
 
@@ -436,6 +535,24 @@ func TestNutsGlobalConfig_InjectIntoEngine(t *testing.T) {
 	cfg := NewNutsGlobalConfig()
 	cfg.Load(&cobra.Command{})
 
+	t.Run("param is not injected into disabled engine", func(t *testing.T) {
+		cfg := NewNutsGlobalConfig()
+		cfg.v.Set(disabledEnginesFlag, []string{"pre"})
+		c := struct {
+			Key string
+		}{}
+		e := &Engine{
+			Config:    &c,
+			ConfigKey: "pre",
+			FlagSet:   pflag.NewFlagSet("dummy", pflag.ContinueOnError),
+		}
+		e.FlagSet.String("key", "", "")
+		cfg.v.Set("pre.key", "value")
+
+		assert.NoError(t, cfg.InjectIntoEngine(e))
+		assert.Empty(t, c.Key)
+	})
+
 	t.Run("param is injected into engine without ConfigKey", func(t *testing.T) {
 		c := struct {
 			Key string
@@ -0,0 +1,175 @@
+/*
+ * Nuts go core
+ * Copyright (C) 2020 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// ErrInvalidEngine is returned when a value is registered that is neither an *Engine nor implements Named
+var ErrInvalidEngine = errors.New("invalid engine")
+
+// Named must be implemented by engine types that are registered without using the Engine struct.
+// The other interfaces in this file are optional, an engine implements the capabilities it needs.
+type Named interface {
+	// Name returns the human readable name of the engine, see Engine.Name
+	Name() string
+}
+
+// ConfigProvider is implemented by engines that read configuration, see Engine.ConfigKey and Engine.Config
+type ConfigProvider interface {
+	// ConfigKey returns the root key of the engine's configuration
+	ConfigKey() string
+	// Config returns a pointer to the engine's config struct
+	Config() interface{}
+}
+
+// FlagProvider is implemented by engines that add command line flags, see Engine.FlagSet
+type FlagProvider interface {
+	// FlagSet returns the engine's flags
+	FlagSet() *pflag.FlagSet
+}
+
+// Configurable is implemented by engines that check their configuration, see Engine.Configure
+type Configurable interface {
+	// Configure checks if the combination of config parameters is allowed
+	Configure() error
+}
+
+// Reconfigurable is implemented by engines that apply config changes at runtime, see Engine.Reconfigure
+type Reconfigurable interface {
+	// Reconfigure validates and applies the given copy of the engine's config holding the new values
+	Reconfigure(config interface{}) error
+}
+
+// Runnable is implemented by engines that start clients, background tasks or other active processes,
+// see Engine.StartContext and Engine.ShutdownContext
+type Runnable interface {
+	// Start starts the engine
+	Start(ctx context.Context) error
+	// Shutdown shuts down the engine, it should return when the context is done
+	Shutdown(ctx context.Context) error
+}
+
+// Diagnosable is implemented by engines that report diagnostics, see Engine.Diagnostics
+type Diagnosable interface {
+	// Diagnostics returns a slice of DiagnosticResult
+	Diagnostics() []DiagnosticResult
+}
+
+// Routable is implemented by engines that serve HTTP routes, see Engine.Routes
+type Routable interface {
+	// Routes registers the engine's routes on the router
+	Routes(router EchoRouter)
+}
+
+// RouteScoper is implemented by Routable engines that want their routes registered under /<ConfigKey>, see Engine.ScopedRoutes
+type RouteScoper interface {
+	// ScopedRoutes returns whether the engine's routes are registered under /<ConfigKey>
+	ScopedRoutes() bool
+}
+
+// AdminRouter is implemented by Routable engines whose routes are administrative, see Engine.AdminRoutes
+type AdminRouter interface {
+	// AdminRoutes returns whether the engine's routes are administrative
+	AdminRoutes() bool
+}
+
+// OpenAPIProvider is implemented by Routable engines that describe their routes in an OpenAPI document, see Engine.OpenAPI
+type OpenAPIProvider interface {
+	// OpenAPI returns the engine's OpenAPI 3 document, in JSON or YAML
+	OpenAPI() ([]byte, error)
+}
+
+// CommandProvider is implemented by engines that add a sub-command, see Engine.Cmd
+type CommandProvider interface {
+	// Cmd returns the engine's sub-command
+	Cmd() *cobra.Command
+}
+
+// Dependent is implemented by engines that depend on other engines, see Engine.Dependencies
+type Dependent interface {
+	// Dependencies returns the Name or ConfigKey of the engines this engine depends on
+	Dependencies() []string
+}
+
+// AdaptEngine returns the Engine for the given value. An *Engine is returned as is. For any other value implementing Named,
+// an Engine is created with its fields set from the optional interfaces the value implements. The value is kept in Engine.Instance.
+func AdaptEngine(instance interface{}) (*Engine, error) {
+	switch i := instance.(type) {
+	case *Engine:
+		if i == nil {
+			return nil, fmt.Errorf("%w: nil *Engine", ErrInvalidEngine)
+		}
+		return i, nil
+	case Named:
+		return adapt(i), nil
+	default:
+		return nil, fmt.Errorf("%w: %T must be an *Engine or implement core.Named", ErrInvalidEngine, instance)
+	}
+}
+
+func adapt(instance Named) *Engine {
+	e := &Engine{Name: instance.Name(), Instance: instance}
+	if i, ok := instance.(ConfigProvider); ok {
+		e.ConfigKey = i.ConfigKey()
+		e.Config = i.Config()
+	}
+	if i, ok := instance.(FlagProvider); ok {
+		e.FlagSet = i.FlagSet()
+	}
+	if i, ok := instance.(Configurable); ok {
+		e.Configure = i.Configure
+	}
+	if i, ok := instance.(Reconfigurable); ok {
+		e.Reconfigure = i.Reconfigure
+	}
+	if i, ok := instance.(Runnable); ok {
+		e.StartContext = i.Start
+		e.ShutdownContext = i.Shutdown
+	}
+	if i, ok := instance.(Diagnosable); ok {
+		e.Diagnostics = i.Diagnostics
+	}
+	if i, ok := instance.(Routable); ok {
+		e.Routes = i.Routes
+	}
+	if i, ok := instance.(RouteScoper); ok {
+		e.ScopedRoutes = i.ScopedRoutes()
+	}
+	if i, ok := instance.(AdminRouter); ok {
+		e.AdminRoutes = i.AdminRoutes()
+	}
+	if i, ok := instance.(OpenAPIProvider); ok {
+		e.OpenAPI = i.OpenAPI
+	}
+	if i, ok := instance.(CommandProvider); ok {
+		e.Cmd = i.Cmd()
+	}
+	if i, ok := instance.(Dependent); ok {
+		e.Dependencies = i.Dependencies()
+	}
+	return e
+}
@@ -0,0 +1,229 @@
+/*
+ * Nuts go core
+ * Copyright (C) 2020 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package core
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/nuts-foundation/nuts-go-core/mock"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+)
+
+type testEngineConfig struct {
+	Key string
+}
+
+// fullEngine implements all engine interfaces
+type fullEngine struct {
+	config testEngineConfig
+	calls  []string
+}
+
+func (f *fullEngine) Name() string {
+	return "Full"
+}
+
+func (f *fullEngine) ConfigKey() string {
+	return "full"
+}
+
+func (f *fullEngine) Config() interface{} {
+	return &f.config
+}
+
+func (f *fullEngine) FlagSet() *pflag.FlagSet {
+	fs := pflag.NewFlagSet("full", pflag.ContinueOnError)
+	fs.String("key", "", "")
+	return fs
+}
+
+func (f *fullEngine) Configure() error {
+	f.calls = append(f.calls, "configure")
+	return nil
+}
+
+func (f *fullEngine) Reconfigure(config interface{}) error {
+	f.calls = append(f.calls, "reconfigure")
+	return nil
+}
+
+func (f *fullEngine) Start(ctx context.Context) error {
+	f.calls = append(f.calls, "start")
+	return nil
+}
+
+func (f *fullEngine) Shutdown(ctx context.Context) error {
+	f.calls = append(f.calls, "shutdown")
+	return nil
+}
+
+func (f *fullEngine) Diagnostics() []DiagnosticResult {
+	return []DiagnosticResult{&GenericDiagnosticResult{Title: "t", Outcome: "o"}}
+}
+
+func (f *fullEngine) Routes(router EchoRouter) {
+	router.GET("/full", StatusOK)
+}
+
+func (f *fullEngine) ScopedRoutes() bool {
+	return true
+}
+
+func (f *fullEngine) Cmd() *cobra.Command {
+	return &cobra.Command{Use: "full"}
+}
+
+func (f *fullEngine) Dependencies() []string {
+	return []string{"other"}
+}
+
+func (f *fullEngine) AdminRoutes() bool {
+	return true
+}
+
+func (f *fullEngine) OpenAPI() ([]byte, error) {
+	return []byte("openapi: 3.0.0"), nil
+}
+
+type namedEngine string
+
+func (n namedEngine) Name() string {
+	return string(n)
+}
+
+func TestAdaptEngine(t *testing.T) {
+	t.Run("Engine is returned as is", func(t *testing.T) {
+		e := &Engine{Name: "A"}
+
+		result, err := AdaptEngine(e)
+
+		assert.NoError(t, err)
+		assert.Same(t, e, result)
+	})
+
+	t.Run("all interfaces are adapted", func(t *testing.T) {
+		f := &fullEngine{}
+
+		e, err := AdaptEngine(f)
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, "Full", e.Name)
+		assert.Equal(t, "full", e.ConfigKey)
+		assert.Same(t, &f.config, e.Config)
+		assert.NotNil(t, e.FlagSet.Lookup("key"))
+		assert.Equal(t, "full", e.Cmd.Use)
+		assert.Equal(t, []string{"other"}, e.Dependencies)
+		assert.True(t, e.ScopedRoutes)
+		assert.True(t, e.AdminRoutes)
+		assert.Len(t, e.Diagnostics(), 1)
+		assert.Same(t, f, e.Instance)
+		if assert.NotNil(t, e.OpenAPI) {
+			doc, _ := e.OpenAPI()
+			assert.Equal(t, "openapi: 3.0.0", string(doc))
+		}
+
+		assert.NoError(t, e.Configure())
+		assert.NoError(t, e.start(context.Background()))
+		assert.NoError(t, e.Reconfigure(nil))
+		assert.NoError(t, e.shutdown(context.Background()))
+		assert.Equal(t, []string{"configure", "start", "reconfigure", "shutdown"}, f.calls)
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		router := mock.NewMockEchoRouter(ctrl)
+		router.EXPECT().GET("/full", gomock.Any())
+		e.Routes(router)
+	})
+
+	t.Run("optional interfaces can be omitted", func(t *testing.T) {
+		e, err := AdaptEngine(namedEngine("A"))
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, "A", e.Name)
+		assert.Nil(t, e.Configure)
+		assert.Nil(t, e.Reconfigure)
+		assert.Nil(t, e.StartContext)
+		assert.Nil(t, e.Routes)
+		assert.Nil(t, e.Diagnostics)
+		assert.Nil(t, e.Cmd)
+		assert.Nil(t, e.OpenAPI)
+	})
+
+	t.Run("error for values not implementing Named", func(t *testing.T) {
+		_, err := AdaptEngine(struct{}{})
+
+		assert.True(t, errors.Is(err, ErrInvalidEngine))
+		assert.Contains(t, err.Error(), "struct {} must be an *Engine or implement core.Named")
+	})
+
+	t.Run("error for nil Engine", func(t *testing.T) {
+		var e *Engine
+
+		_, err := AdaptEngine(e)
+
+		assert.True(t, errors.Is(err, ErrInvalidEngine))
+	})
+}
+
+func TestEngineControl_RegisterInstance(t *testing.T) {
+	t.Run("adapted engine takes part in the lifecycle", func(t *testing.T) {
+		ctl := NewEngineControl()
+		f := &fullEngine{}
+		_ = ctl.Register(&Engine{Name: "other"})
+
+		err := ctl.RegisterInstance(f)
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Same(t, f, ctl.Get("full").Instance)
+		assert.NoError(t, ctl.Configure())
+		assert.NoError(t, ctl.Start())
+		assert.NoError(t, ctl.Shutdown())
+		assert.Equal(t, []string{"configure", "start", "shutdown"}, f.calls)
+	})
+
+	t.Run("duplicates are detected", func(t *testing.T) {
+		ctl := NewEngineControl()
+		_ = ctl.Register(&Engine{Name: "Full"})
+
+		err := ctl.RegisterInstance(&fullEngine{})
+
+		assert.True(t, errors.Is(err, ErrDuplicateEngineName))
+	})
+
+	t.Run("invalid values are rejected", func(t *testing.T) {
+		ctl := NewEngineControl()
+
+		err := ctl.RegisterInstance("engine")
+
+		assert.True(t, errors.Is(err, ErrInvalidEngine))
+		assert.Empty(t, ctl.All())
+	})
+}
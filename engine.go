@@ -20,32 +20,78 @@
 package core
 
 import (
-	"net/url"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 )
 
-// EngineCtl is the control structure where engines are registered. All registered engines are referenced by the EngineCtl
+// EngineCtl is the control structure where engines are registered. All registered engines are referenced by the EngineCtl.
+// Register, Get and All are safe for concurrent use.
 type EngineControl struct {
-	// Engines is the slice of all registered engines
+	// Engines is the slice of all registered engines.
+	//
+	// Deprecated: use Register to add engines and All to iterate over them.
 	Engines []*Engine
+
+	// disabled holds the engines disabled by SelectEngines
+	disabled map[*Engine]bool
+
+	// mutex guards Engines and disabled
+	mutex sync.RWMutex
+
+	// ShutdownTimeout is the default time an engine may take to shut down, 0 means no limit.
+	// It can be overridden per engine using Engine.ShutdownTimeout. Use SetShutdownTimeout to change it once engines are running.
+	ShutdownTimeout time.Duration
+
+	// started holds the engines started by Start, in start order
+	started []*Engine
+	// running is set by Start and cleared when the started engines are shut down
+	running bool
+
+	// states holds the lifecycle status of every registered engine
+	states map[*Engine]*EngineStatus
+	// supervisors holds the Supervisor of every engine that has background tasks
+	supervisors map[*Engine]*Supervisor
+	// events is the EventBus shared by the engines
+	events *EventBus
+	// routes is the route table of the HTTP server
+	routes []RouteInfo
+	// stateMutex guards ShutdownTimeout (once engines are running), started, running, states, supervisors, events and routes
+	stateMutex sync.RWMutex
 }
 
+// EngineCtl is the default registry, used by RegisterEngine and the functions that don't take an explicit EngineControl.
+// Use NewEngineControl to create independent registries, e.g. to run multiple nodes in a single process.
 var EngineCtl EngineControl
 
-// EchoRouter is the interface the generated server API's will require as the Routes func argument
+// NewEngineControl creates a new, empty engine registry
+func NewEngineControl() *EngineControl {
+	return &EngineControl{}
+}
+
+// EchoRouter is the interface the generated server API's will require as the Routes func argument.
+// Besides the functions used by generated server API's, it allows engines to register routes for any method, to create
+// route groups with their own middleware and to add middleware to all their routes. *echo.Echo and *echo.Group implement it.
 type EchoRouter interface {
+	Any(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) []*echo.Route
 	CONNECT(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route
 	DELETE(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route
 	GET(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route
+	Group(prefix string, m ...echo.MiddlewareFunc) *echo.Group
 	HEAD(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route
 	OPTIONS(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route
 	PATCH(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route
 	POST(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route
 	PUT(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route
 	TRACE(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route
+	Use(m ...echo.MiddlewareFunc)
 }
 
 // START_DOC_ENGINE_1
@@ -55,6 +101,10 @@ type Engine struct {
 	// Name holds the human readable name of the engine
 	Name string
 
+	// AdminRoutes marks the engine's routes as administrative. When an admin address is configured, they're served on that
+	// address instead of on the server address, see NutsConfigValues.AdminAddress.
+	AdminRoutes bool
+
 	// Cmd is the optional sub-command for the engine. An engine can only add one sub-command (but multiple sub-sub-commands for the sub-command)
 	Cmd *cobra.Command
 
@@ -73,49 +123,245 @@ type Engine struct {
 	// Configure checks if the combination of config parameters is allowed
 	Configure func() error
 
+	// Dependencies lists the engines (by Name or ConfigKey) that must be configured and started before this engine.
+	// Engines are shut down in the reverse order.
+	Dependencies []string
+
 	// Diagnostics returns a slice of DiagnosticResult
 	Diagnostics func() []DiagnosticResult
 
 	// FlasSet contains all engine-local configuration possibilities so they can be displayed through the help command
 	FlagSet *pflag.FlagSet
 
+	// Instance is the value the engine was created from by AdaptEngine, nil when the Engine was defined directly.
+	Instance interface{}
+
+	// OpenAPI returns the OpenAPI 3 document (JSON or YAML) describing the engine's routes, relative to the router passed to Routes.
+	// The documents of the engines are combined into the node's OpenAPI document, see OpenAPI.
+	OpenAPI func() ([]byte, error)
+
+	// Reconfigure applies a changed configuration while the engine is running, see NutsGlobalConfig.Reload.
+	// It's called with a fresh copy of Config holding the new values. The engine validates and applies it, when it returns
+	// an error the engine keeps its current configuration. Without Reconfigure, config changes require a restart.
+	Reconfigure func(config interface{}) error
+
 	// Routes passes the Echo router to the specific engine for it to register their routes.
 	Routes func(router EchoRouter)
 
+	// ScopedRoutes registers the engine's routes under the /<ConfigKey> prefix: Routes receives a router for that prefix.
+	// It has no effect when ConfigKey is empty.
+	ScopedRoutes bool
+
 	// Shutdown the engine
 	Shutdown func() error
 
+	// ShutdownContext shuts down the engine, it takes precedence over Shutdown. The engine should return when the context is done.
+	ShutdownContext func(ctx context.Context) error
+
+	// ShutdownTimeout is the time the engine may take to shut down, it overrides EngineControl.ShutdownTimeout when set.
+	ShutdownTimeout time.Duration
+
 	// Start the engine, this will spawn any clients, background tasks or active processes.
 	Start func() error
+
+	// StartContext starts the engine, it takes precedence over Start.
+	StartContext func(ctx context.Context) error
 }
 
 // END_DOC_ENGINE_1
 
-// DecodeURIPath is a echo middleware that decodes path parameters
-func DecodeURIPath(next echo.HandlerFunc) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		// FIXME: This is a hack because of https://github.com/labstack/echo/issues/1258
-		newValues := make([]string, len(c.ParamValues()))
-		for i, value := range c.ParamValues() {
-			path, err := url.PathUnescape(value)
-			if err != nil {
-				path = value
+// ErrDuplicateEngineName is returned when an engine is registered with a Name that's already in use
+var ErrDuplicateEngineName = errors.New("duplicate engine name")
+
+// ErrDuplicateConfigKey is returned when an engine is registered with a ConfigKey that's already in use
+var ErrDuplicateConfigKey = errors.New("duplicate engine config key")
+
+// ErrDuplicateFlag is returned when an engine is registered with a flag that clashes with a global flag or a flag of another engine
+var ErrDuplicateFlag = errors.New("duplicate flag")
+
+// ErrUnknownEngine is returned when the config enables or disables an engine that isn't registered
+var ErrUnknownEngine = errors.New("unknown engine")
+
+// ErrDisabledDependency is returned when an enabled engine depends on a disabled engine
+var ErrDisabledDependency = errors.New("engine depends on disabled engine")
+
+// RegisterEngine is a helper func to add an engine to the list of engines from a different lib/pkg.
+// It panics when the engine can't be registered, see EngineControl.Register.
+func RegisterEngine(engine *Engine) {
+	if err := EngineCtl.Register(engine); err != nil {
+		panic(err)
+	}
+}
+
+// RegisterEngineInstance adds an engine type implementing Named and the optional engine interfaces to EngineCtl, see EngineControl.RegisterInstance.
+func RegisterEngineInstance(instance interface{}) error {
+	return EngineCtl.RegisterInstance(instance)
+}
+
+// Register adds an engine to the registry. It returns an error when the Name or ConfigKey of the engine is already used by
+// another engine, or when one of its flags has the same name as a global flag or a flag of another engine. Engines without
+// a Name or ConfigKey are not checked for duplicates on that attribute.
+func (ec *EngineControl) Register(engine *Engine) error {
+	return ec.RegisterInstance(engine)
+}
+
+// RegisterInstance adds an engine to the registry. The engine is either an *Engine or a value implementing Named and the optional
+// engine interfaces, see AdaptEngine. It returns an error when the engine can't be registered, see Register.
+func (ec *EngineControl) RegisterInstance(instance interface{}) error {
+	engine, err := AdaptEngine(instance)
+	if err != nil {
+		return err
+	}
+
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+
+	if err := validateRegistration(ec.Engines, engine); err != nil {
+		return err
+	}
+	ec.Engines = append(ec.Engines, engine)
+	ec.transition(engine, EngineRegistered, nil)
+	return nil
+}
+
+// Get returns the engine with the given Name or ConfigKey, nil if there's no such engine.
+func (ec *EngineControl) Get(nameOrKey string) *Engine {
+	ec.mutex.RLock()
+	defer ec.mutex.RUnlock()
+
+	return findEngine(ec.Engines, nameOrKey)
+}
+
+// All returns a snapshot of the registered engines in registration order. Engines registered afterwards are not included.
+func (ec *EngineControl) All() []*Engine {
+	ec.mutex.RLock()
+	defer ec.mutex.RUnlock()
+
+	return append([]*Engine{}, ec.Engines...)
+}
+
+// Enabled returns a snapshot of the registered engines that aren't disabled by SelectEngines, in registration order.
+func (ec *EngineControl) Enabled() []*Engine {
+	ec.mutex.RLock()
+	defer ec.mutex.RUnlock()
+
+	var enabled []*Engine
+	for _, e := range ec.Engines {
+		if !ec.disabled[e] {
+			enabled = append(enabled, e)
+		}
+	}
+	return enabled
+}
+
+// IsEnabled returns whether the given engine isn't disabled by SelectEngines
+func (ec *EngineControl) IsEnabled(e *Engine) bool {
+	ec.mutex.RLock()
+	defer ec.mutex.RUnlock()
+
+	return !ec.disabled[e]
+}
+
+// SelectEngines disables the engines that aren't enabled by the given config, see NutsConfigValues.EnabledEngines and
+// NutsConfigValues.DisabledEngines. Disabled engines are not configured, started or routed and have the EngineDisabled state.
+// It returns an error when the config refers to an unknown ConfigKey or when an enabled engine depends on a disabled engine.
+func (ec *EngineControl) SelectEngines(config NutsConfigValues) error {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+
+	for _, key := range append(config.EnabledEngines(), config.DisabledEngines()...) {
+		if !hasConfigKey(ec.Engines, key) {
+			return fmt.Errorf("%w: %s", ErrUnknownEngine, key)
+		}
+	}
+	disabled := map[*Engine]bool{}
+	for _, e := range ec.Engines {
+		if !engineEnabled(config, e.ConfigKey) {
+			disabled[e] = true
+		}
+	}
+	for _, e := range ec.Engines {
+		if disabled[e] {
+			continue
+		}
+		for _, dependency := range e.Dependencies {
+			if d := findEngine(ec.Engines, dependency); d != nil && disabled[d] {
+				return fmt.Errorf("%w: engine %s depends on %s", ErrDisabledDependency, e.Name, d.Name)
 			}
-			newValues[i] = path
 		}
-		c.SetParamNames(c.ParamNames()...)
-		c.SetParamValues(newValues...)
-		return next(c)
 	}
+
+	for _, e := range ec.Engines {
+		if disabled[e] {
+			ec.transition(e, EngineDisabled, nil)
+		} else if ec.disabled[e] {
+			ec.transition(e, EngineRegistered, nil)
+		}
+	}
+	ec.disabled = disabled
+	return nil
 }
 
-// RegisterEngine is a helper func to add an engine to the list of engines from a different lib/pkg
-func RegisterEngine(engine *Engine) {
-	EngineCtl.registerEngine(engine)
+func hasConfigKey(engines []*Engine, key string) bool {
+	for _, e := range engines {
+		if e.ConfigKey == key {
+			return true
+		}
+	}
+	return false
 }
 
-func (ec *EngineControl) registerEngine(engine *Engine) {
-	ec.Engines = append(ec.Engines, engine)
+func validateRegistration(registered []*Engine, engine *Engine) error {
+	flags := map[string]bool{}
+	for _, f := range globalFlags {
+		flags[f] = true
+	}
+	for _, other := range registered {
+		if engine.Name != "" && other.Name == engine.Name {
+			return fmt.Errorf("%w: %s", ErrDuplicateEngineName, engine.Name)
+		}
+		if engine.ConfigKey != "" && other.ConfigKey == engine.ConfigKey {
+			return fmt.Errorf("%w: %s (used by %s and %s)", ErrDuplicateConfigKey, engine.ConfigKey, other.Name, engine.Name)
+		}
+		for _, name := range flagNames(other) {
+			flags[name] = true
+		}
+	}
+	for _, name := range flagNames(engine) {
+		if flags[name] {
+			return fmt.Errorf("%w: %s (engine %s)", ErrDuplicateFlag, name, engine.Name)
+		}
+	}
+	return nil
+}
+
+// flagNames returns the names of the engine's flags, prefixed with its ConfigKey the way RegisterFlags does with the default settings.
+func flagNames(e *Engine) []string {
+	var names []string
+	if e.FlagSet == nil {
+		return names
+	}
+	prefix := ""
+	if e.ConfigKey != "" && !isDefaultIgnoredPrefix(e.ConfigKey) {
+		prefix = e.ConfigKey + defaultSeparator
+	}
+	e.FlagSet.VisitAll(func(f *pflag.Flag) {
+		if prefix != "" && !strings.HasPrefix(f.Name, prefix) {
+			names = append(names, prefix+f.Name)
+		} else {
+			names = append(names, f.Name)
+		}
+	})
+	return names
+}
+
+func isDefaultIgnoredPrefix(prefix string) bool {
+	for _, p := range defaultIgnoredPrefixes {
+		if p == prefix {
+			return true
+		}
+	}
+	return false
 }
 
 func init() {
@@ -20,30 +20,235 @@
 package core
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"sync"
 	"testing"
 
 	"github.com/golang/mock/gomock"
 	"github.com/labstack/echo/v4"
 	"github.com/nuts-foundation/nuts-go-core/mock"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestRegisterEngine(t *testing.T) {
+	t.Run("panics when the engine can't be registered", func(t *testing.T) {
+		assert.Panics(t, func() {
+			RegisterEngine(nil)
+		})
+	})
+
 	t.Run("adds an engine to the list", func(t *testing.T) {
 		ctl := EngineControl{
 			Engines: []*Engine{},
 		}
-		ctl.registerEngine(&Engine{})
+		ctl.Register(&Engine{})
 
 		if len(ctl.Engines) != 1 {
 			t.Errorf("Expected 1 registered engine, Got %d", len(ctl.Engines))
 		}
 	})
+
+	t.Run("rejects duplicate name", func(t *testing.T) {
+		ctl := EngineControl{}
+		_ = ctl.Register(&Engine{Name: "A"})
+
+		err := ctl.Register(&Engine{Name: "A"})
+
+		assert.True(t, errors.Is(err, ErrDuplicateEngineName))
+		assert.Len(t, ctl.All(), 1)
+	})
+
+	t.Run("rejects duplicate config key", func(t *testing.T) {
+		ctl := EngineControl{}
+		_ = ctl.Register(&Engine{Name: "A", ConfigKey: "key"})
+
+		err := ctl.Register(&Engine{Name: "B", ConfigKey: "key"})
+
+		assert.Equal(t, "duplicate engine config key: key (used by A and B)", err.Error())
+	})
+
+	t.Run("allows multiple engines without name or config key", func(t *testing.T) {
+		ctl := EngineControl{}
+
+		assert.NoError(t, ctl.Register(&Engine{}))
+		assert.NoError(t, ctl.Register(&Engine{}))
+	})
+
+	t.Run("rejects flag clashing with a global flag", func(t *testing.T) {
+		ctl := EngineControl{}
+		fs := pflag.NewFlagSet("a", pflag.ContinueOnError)
+		fs.String(addressFlag, "", "")
+
+		err := ctl.Register(&Engine{Name: "A", FlagSet: fs})
+
+		assert.Equal(t, "duplicate flag: address (engine A)", err.Error())
+	})
+
+	t.Run("rejects flag clashing with a flag of another engine", func(t *testing.T) {
+		ctl := EngineControl{}
+		fsA := pflag.NewFlagSet("a", pflag.ContinueOnError)
+		fsA.String("key", "", "")
+		fsB := pflag.NewFlagSet("b", pflag.ContinueOnError)
+		fsB.String("key", "", "")
+		_ = ctl.Register(&Engine{Name: "A", FlagSet: fsA})
+
+		err := ctl.Register(&Engine{Name: "B", FlagSet: fsB})
+
+		assert.True(t, errors.Is(err, ErrDuplicateFlag))
+	})
+
+	t.Run("allows same flag name under different config keys", func(t *testing.T) {
+		ctl := EngineControl{}
+		fsA := pflag.NewFlagSet("a", pflag.ContinueOnError)
+		fsA.String(addressFlag, "", "")
+		fsB := pflag.NewFlagSet("b", pflag.ContinueOnError)
+		fsB.String(addressFlag, "", "")
+
+		assert.NoError(t, ctl.Register(&Engine{Name: "A", ConfigKey: "a", FlagSet: fsA}))
+		assert.NoError(t, ctl.Register(&Engine{Name: "B", ConfigKey: "b", FlagSet: fsB}))
+	})
+
+	t.Run("flags already prefixed by RegisterFlags are not prefixed twice", func(t *testing.T) {
+		ctl := EngineControl{}
+		fsA := pflag.NewFlagSet("a", pflag.ContinueOnError)
+		fsA.String("key", "", "")
+		fsB := pflag.NewFlagSet("b", pflag.ContinueOnError)
+		fsB.String("a.key", "", "")
+		a := &Engine{Name: "A", ConfigKey: "a", FlagSet: fsA}
+		NewNutsGlobalConfig().RegisterFlags(&cobra.Command{}, a)
+		_ = ctl.Register(a)
+
+		err := ctl.Register(&Engine{Name: "B", FlagSet: fsB})
+
+		assert.True(t, errors.Is(err, ErrDuplicateFlag))
+	})
+
+	t.Run("is safe for concurrent use", func(t *testing.T) {
+		ctl := EngineControl{}
+		wg := sync.WaitGroup{}
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				_ = ctl.Register(&Engine{Name: fmt.Sprintf("engine %d", i)})
+				_ = ctl.Get("engine 0")
+				_ = ctl.All()
+			}(i)
+		}
+		wg.Wait()
+
+		assert.Len(t, ctl.All(), 10)
+	})
+}
+
+func TestEngineControl_Get(t *testing.T) {
+	ctl := EngineControl{}
+	a := &Engine{Name: "A", ConfigKey: "a"}
+	_ = ctl.Register(a)
+
+	assert.Same(t, a, ctl.Get("A"))
+	assert.Same(t, a, ctl.Get("a"))
+	assert.Nil(t, ctl.Get("B"))
+}
+
+func TestEngineControl_All(t *testing.T) {
+	ctl := EngineControl{}
+	_ = ctl.Register(&Engine{Name: "A"})
+
+	snapshot := ctl.All()
+	_ = ctl.Register(&Engine{Name: "B"})
+
+	assert.Len(t, snapshot, 1)
+	assert.Len(t, ctl.All(), 2)
+}
+
+func TestEngineControl_SelectEngines(t *testing.T) {
+	config := func(enabled []string, disabled []string) NutsConfigValues {
+		cfg := NewNutsGlobalConfig()
+		cfg.v.Set(enabledEnginesFlag, enabled)
+		cfg.v.Set(disabledEnginesFlag, disabled)
+		return cfg
+	}
+	engines := func() *EngineControl {
+		ctl := NewEngineControl()
+		_ = ctl.Register(&Engine{Name: "Crypto", ConfigKey: "crypto"})
+		_ = ctl.Register(&Engine{Name: "Registry", ConfigKey: "registry", Dependencies: []string{"crypto"}})
+		_ = ctl.Register(&Engine{Name: "Metrics", ConfigKey: "metrics"})
+		_ = ctl.Register(&Engine{Name: "Status"})
+		return ctl
+	}
+
+	t.Run("disabled engines are excluded from the lifecycle", func(t *testing.T) {
+		ctl := engines()
+
+		err := ctl.SelectEngines(config(nil, []string{"metrics"}))
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"Crypto", "Registry", "Status"}, engineNames(ctl.Enabled()))
+		ordered, _ := ctl.StartOrder()
+		assert.Equal(t, []string{"Crypto", "Registry", "Status"}, engineNames(ordered))
+		assert.Len(t, ctl.All(), 4)
+		status, _ := ctl.Status("metrics")
+		assert.Equal(t, EngineDisabled, status.State)
+		assert.False(t, ctl.IsEnabled(ctl.Get("metrics")))
+	})
+
+	t.Run("only enabled engines and engines without ConfigKey", func(t *testing.T) {
+		ctl := engines()
+
+		err := ctl.SelectEngines(config([]string{"crypto"}, nil))
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"Crypto", "Status"}, engineNames(ctl.Enabled()))
+	})
+
+	t.Run("engines can be enabled again", func(t *testing.T) {
+		ctl := engines()
+		_ = ctl.SelectEngines(config(nil, []string{"metrics"}))
+
+		err := ctl.SelectEngines(config(nil, nil))
+
+		assert.NoError(t, err)
+		assert.Len(t, ctl.Enabled(), 4)
+		status, _ := ctl.Status("metrics")
+		assert.Equal(t, EngineRegistered, status.State)
+	})
+
+	t.Run("error when a dependency is disabled", func(t *testing.T) {
+		ctl := engines()
+
+		err := ctl.SelectEngines(config(nil, []string{"crypto"}))
+
+		assert.True(t, errors.Is(err, ErrDisabledDependency))
+		assert.EqualError(t, err, "engine depends on disabled engine: engine Registry depends on Crypto")
+		assert.Len(t, ctl.Enabled(), 4)
+	})
+
+	t.Run("error on unknown engine", func(t *testing.T) {
+		ctl := engines()
+
+		err := ctl.SelectEngines(config([]string{"consent"}, nil))
+
+		assert.True(t, errors.Is(err, ErrUnknownEngine))
+	})
+
+	t.Run("disabled engines are not part of the diagnostics", func(t *testing.T) {
+		ctl := engines()
+		ctl.Get("metrics").Diagnostics = func() []DiagnosticResult {
+			return []DiagnosticResult{&GenericDiagnosticResult{Title: "t", Outcome: "o"}}
+		}
+		_ = ctl.SelectEngines(config(nil, []string{"metrics"}))
+
+		assert.Empty(t, diagnosticsSummaryAsText(ctl))
+	})
 }
 
 func TestNewStatusEngine_Routes(t *testing.T) {
@@ -53,7 +258,12 @@ func TestNewStatusEngine_Routes(t *testing.T) {
 		echo := mock.NewMockEchoRouter(ctrl)
 
 		echo.EXPECT().GET("/status/diagnostics", gomock.Any())
-		echo.EXPECT().GET("/status", gomock.Any())
+		echo.EXPECT().GET("/status/engines", gomock.Any())
+		echo.EXPECT().GET("/status/routes", gomock.Any())
+		echo.EXPECT().GET("/status/openapi", gomock.Any())
+		echo.EXPECT().GET("/status/openapi/engines", gomock.Any())
+		echo.EXPECT().GET("/status/openapi/engines/:name", gomock.Any())
+		echo.EXPECT().GET("/status", gomock.Any(), gomock.Any())
 
 		NewStatusEngine().Routes(echo)
 	})
@@ -81,12 +291,14 @@ func TestNewStatusEngine_Cmd(t *testing.T) {
 }
 
 func TestNewStatusEngine_Diagnostics(t *testing.T) {
-	RegisterEngine(NewStatusEngine())
-	RegisterEngine(NewLoggerEngine())
-	RegisterEngine(NewMetricsEngine())
+	ctl := NewEngineControl()
+	statusEngine := NewStatusEngineFor(ctl)
+	ctl.Register(statusEngine)
+	ctl.Register(NewLoggerEngine())
+	ctl.Register(NewMetricsEngine())
 
 	t.Run("diagnostics() returns engine list", func(t *testing.T) {
-		ds := NewStatusEngine().Diagnostics()
+		ds := statusEngine.Diagnostics()
 		assert.Len(t, ds, 1)
 		assert.Equal(t, "Registered engines", ds[0].Name())
 		assert.Equal(t, "Status,Logging,Metrics", ds[0].String())
@@ -99,10 +311,34 @@ func TestNewStatusEngine_Diagnostics(t *testing.T) {
 
 		echo.EXPECT().String(http.StatusOK, "Status\n\tRegistered engines: Status,Logging,Metrics\nLogging\n\tverbosity: ")
 
-		diagnosticsOverview(echo)
+		diagnosticsOverview(ctl)(echo)
+	})
+
+	t.Run("only lists engines of its own registry", func(t *testing.T) {
+		ds := NewStatusEngineFor(NewEngineControl()).Diagnostics()
+		assert.Equal(t, "", ds[0].String())
 	})
 }
 
+func TestNewStatusEngine_EngineStatuses(t *testing.T) {
+	ctl := NewEngineControl()
+	ctl.Register(&Engine{Name: "A"})
+	e := echo.New()
+	NewStatusEngineFor(ctl).Routes(e)
+	req := httptest.NewRequest(http.MethodGet, "/status/engines", nil)
+	rec := httptest.NewRecorder()
+
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var statuses []EngineStatus
+	if !assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &statuses)) {
+		return
+	}
+	assert.Len(t, statuses, 1)
+	assert.Equal(t, "A", statuses[0].Name)
+}
+
 func TestStatusOK(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -112,41 +348,3 @@ func TestStatusOK(t *testing.T) {
 
 	StatusOK(echo)
 }
-
-func TestDecodeURIPath(t *testing.T) {
-	rawParam := "urn:oid:2.16.840.1.113883.2.4.6.1:87654321"
-	encodedParam := "urn%3Aoid%3A2.16.840.1.113883.2.4.6.1%3A87654321"
-
-	t.Run("without middleware, it returns the encoded param", func(t *testing.T) {
-		e := echo.New()
-		r := e.Router()
-		r.Add(http.MethodGet, "/api/:someparam", func(context echo.Context) error {
-			param := context.Param("someparam")
-			return context.Blob(200, "text/plain", []byte(param))
-		})
-
-		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/%v", encodedParam), nil)
-		rec := httptest.NewRecorder()
-		e.ServeHTTP(rec, req)
-		defer rec.Result().Body.Close()
-		bodyBytes, _ := ioutil.ReadAll(rec.Result().Body)
-		assert.Equal(t, encodedParam, string(bodyBytes))
-	})
-
-	t.Run("with middleware, it return the decoded param", func(t *testing.T) {
-		e := echo.New()
-		r := e.Router()
-		e.Use(DecodeURIPath)
-		r.Add(http.MethodGet, "/api/:someparam", func(context echo.Context) error {
-			param := context.Param("someparam")
-			return context.Blob(200, "text/plain", []byte(param))
-		})
-
-		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/%v", encodedParam), nil)
-		rec := httptest.NewRecorder()
-		e.ServeHTTP(rec, req)
-		defer rec.Result().Body.Close()
-		bodyBytes, _ := ioutil.ReadAll(rec.Result().Body)
-		assert.Equal(t, rawParam, string(bodyBytes))
-	})
-}
@@ -0,0 +1,247 @@
+/*
+ * Nuts go core
+ * Copyright (C) 2020 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrInvalidPayload is returned when an event is published with a payload that doesn't match the type of the topic
+var ErrInvalidPayload = errors.New("invalid event payload")
+
+// ErrTopicTypeMismatch is returned when a topic is used with a different payload type than it was first used with
+var ErrTopicTypeMismatch = errors.New("topic payload type mismatch")
+
+// ErrInvalidQueueSize is returned when subscribing asynchronously with a queue size smaller than 1
+var ErrInvalidQueueSize = errors.New("invalid queue size")
+
+var (
+	eventsPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: NutsMetricsPrefix + "eventbus_published_total",
+		Help: "Number of events published per topic.",
+	}, []string{"topic"})
+	eventsDelivered = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: NutsMetricsPrefix + "eventbus_delivered_total",
+		Help: "Number of events delivered to subscribers per topic and delivery mode.",
+	}, []string{"topic", "mode"})
+	eventsDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: NutsMetricsPrefix + "eventbus_dropped_total",
+		Help: "Number of events dropped because the queue of an asynchronous subscriber was full.",
+	}, []string{"topic"})
+	eventHandlerErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: NutsMetricsPrefix + "eventbus_handler_errors_total",
+		Help: "Number of events for which the subscriber's handler returned an error or panicked.",
+	}, []string{"topic"})
+)
+
+const (
+	syncDelivery  = "sync"
+	asyncDelivery = "async"
+)
+
+// Topic identifies a stream of events with a fixed payload type
+type Topic struct {
+	// Name is the unique name of the topic, e.g. "registry.vendor-registered"
+	Name        string
+	payloadType reflect.Type
+}
+
+// NewTopic creates a new Topic. The payload type of the topic is the type of the given example value, e.g.
+// NewTopic("registry.vendor-registered", VendorRegistered{}).
+func NewTopic(name string, example interface{}) Topic {
+	return Topic{Name: name, payloadType: reflect.TypeOf(example)}
+}
+
+// EventHandler handles the payload of an event. The payload always has the type of the topic.
+type EventHandler func(payload interface{}) error
+
+// Subscription is a handler subscribed to a topic
+type Subscription struct {
+	bus     *EventBus
+	topic   string
+	handler EventHandler
+	// queue is nil for synchronous subscriptions
+	queue chan interface{}
+	done  chan struct{}
+}
+
+// EventBus delivers events published by one engine to the engines subscribed to its topic, within the same process.
+// All functions are safe for concurrent use.
+type EventBus struct {
+	mutex         sync.Mutex
+	types         map[string]reflect.Type
+	subscriptions map[string][]*Subscription
+}
+
+// NewEventBus creates a new, empty EventBus
+func NewEventBus() *EventBus {
+	return &EventBus{
+		types:         map[string]reflect.Type{},
+		subscriptions: map[string][]*Subscription{},
+	}
+}
+
+// Events returns the EventBus shared by the engines of this EngineControl
+func (ec *EngineControl) Events() *EventBus {
+	ec.stateMutex.Lock()
+	defer ec.stateMutex.Unlock()
+	if ec.events == nil {
+		ec.events = NewEventBus()
+	}
+	return ec.events
+}
+
+// Subscribe subscribes the handler to the topic. The handler is called synchronously by Publish, in the publisher's goroutine.
+func (b *EventBus) Subscribe(topic Topic, handler EventHandler) (*Subscription, error) {
+	return b.subscribe(topic, &Subscription{handler: handler})
+}
+
+// SubscribeAsync subscribes the handler to the topic. Events are queued and the handler is called from a separate goroutine,
+// in publishing order. When the queue holds queueSize events, new events for this subscriber are dropped.
+// It returns ErrInvalidQueueSize when queueSize is smaller than 1.
+func (b *EventBus) SubscribeAsync(topic Topic, handler EventHandler, queueSize int) (*Subscription, error) {
+	if queueSize < 1 {
+		return nil, fmt.Errorf("%w: %d, must be at least 1", ErrInvalidQueueSize, queueSize)
+	}
+	s := &Subscription{
+		handler: handler,
+		queue:   make(chan interface{}, queueSize),
+		done:    make(chan struct{}),
+	}
+	if _, err := b.subscribe(topic, s); err != nil {
+		return nil, err
+	}
+	go func() {
+		defer close(s.done)
+		for payload := range s.queue {
+			s.deliver(payload, asyncDelivery)
+		}
+	}()
+	return s, nil
+}
+
+func (b *EventBus) subscribe(topic Topic, s *Subscription) (*Subscription, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if err := b.checkType(topic); err != nil {
+		return nil, err
+	}
+	s.bus = b
+	s.topic = topic.Name
+	b.subscriptions[topic.Name] = append(b.subscriptions[topic.Name], s)
+	return s, nil
+}
+
+// checkType registers the payload type of the topic on first use, or checks it against the registered type. Caller must hold the lock.
+func (b *EventBus) checkType(topic Topic) error {
+	registered, ok := b.types[topic.Name]
+	if !ok {
+		b.types[topic.Name] = topic.payloadType
+		return nil
+	}
+	if registered != topic.payloadType {
+		return fmt.Errorf("%w: topic %s has payload type %v, not %v", ErrTopicTypeMismatch, topic.Name, registered, topic.payloadType)
+	}
+	return nil
+}
+
+// Publish publishes an event on the topic. Synchronous subscribers are called before Publish returns, the first error
+// returned by one of them is returned. Events for asynchronous subscribers are queued (or dropped when the queue is full).
+func (b *EventBus) Publish(topic Topic, payload interface{}) error {
+	if reflect.TypeOf(payload) != topic.payloadType {
+		return fmt.Errorf("%w: topic %s expects %v, got %T", ErrInvalidPayload, topic.Name, topic.payloadType, payload)
+	}
+
+	b.mutex.Lock()
+	if err := b.checkType(topic); err != nil {
+		b.mutex.Unlock()
+		return err
+	}
+	subscriptions := append([]*Subscription{}, b.subscriptions[topic.Name]...)
+	eventsPublished.WithLabelValues(topic.Name).Inc()
+	// queue for async subscribers while holding the lock, so Unsubscribe can't close the queue in the meantime
+	for _, s := range subscriptions {
+		if s.queue == nil {
+			continue
+		}
+		select {
+		case s.queue <- payload:
+		default:
+			eventsDropped.WithLabelValues(topic.Name).Inc()
+			log.Warnf("Event queue full, dropping event on topic %s", topic.Name)
+		}
+	}
+	b.mutex.Unlock()
+
+	var result error
+	for _, s := range subscriptions {
+		if s.queue != nil {
+			continue
+		}
+		if err := s.deliver(payload, syncDelivery); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
+
+// deliver calls the handler, converting a panic into an error
+func (s *Subscription) deliver(payload interface{}, mode string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("event handler for topic %s panicked: %v", s.topic, r)
+		}
+		if err != nil {
+			eventHandlerErrors.WithLabelValues(s.topic).Inc()
+			log.Errorf("Error handling event on topic %s: %v", s.topic, err)
+		} else {
+			eventsDelivered.WithLabelValues(s.topic, mode).Inc()
+		}
+	}()
+	return s.handler(payload)
+}
+
+// Unsubscribe removes the subscription from the bus. For asynchronous subscriptions, it waits until the queued events are handled.
+func (s *Subscription) Unsubscribe() {
+	b := s.bus
+	b.mutex.Lock()
+	subscriptions := b.subscriptions[s.topic]
+	for i, other := range subscriptions {
+		if other == s {
+			b.subscriptions[s.topic] = append(subscriptions[:i:i], subscriptions[i+1:]...)
+			if s.queue != nil {
+				close(s.queue)
+			}
+			break
+		}
+	}
+	b.mutex.Unlock()
+
+	if s.done != nil {
+		<-s.done
+	}
+}
@@ -0,0 +1,229 @@
+/*
+ * Nuts go core
+ * Copyright (C) 2020 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package core
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+type vendorRegistered struct {
+	Name string
+}
+
+func TestEventBus_Subscribe(t *testing.T) {
+	t.Run("synchronous subscribers receive the payload before Publish returns", func(t *testing.T) {
+		bus := NewEventBus()
+		topic := NewTopic("test.sync", vendorRegistered{})
+		var received []string
+		for i := 0; i < 2; i++ {
+			_, _ = bus.Subscribe(topic, func(payload interface{}) error {
+				received = append(received, payload.(vendorRegistered).Name)
+				return nil
+			})
+		}
+
+		published := testutil.ToFloat64(eventsPublished.WithLabelValues("test.sync"))
+		delivered := testutil.ToFloat64(eventsDelivered.WithLabelValues("test.sync", syncDelivery))
+
+		err := bus.Publish(topic, vendorRegistered{Name: "vendor"})
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"vendor", "vendor"}, received)
+		assert.Equal(t, published+1, testutil.ToFloat64(eventsPublished.WithLabelValues("test.sync")))
+		assert.Equal(t, delivered+2, testutil.ToFloat64(eventsDelivered.WithLabelValues("test.sync", syncDelivery)))
+	})
+
+	t.Run("first handler error is returned, other handlers are still called", func(t *testing.T) {
+		bus := NewEventBus()
+		topic := NewTopic("test.error", vendorRegistered{})
+		calls := 0
+		_, _ = bus.Subscribe(topic, func(payload interface{}) error {
+			calls++
+			return errors.New("failed")
+		})
+		_, _ = bus.Subscribe(topic, func(payload interface{}) error {
+			calls++
+			panic("boom")
+		})
+
+		handlerErrors := testutil.ToFloat64(eventHandlerErrors.WithLabelValues("test.error"))
+
+		err := bus.Publish(topic, vendorRegistered{})
+
+		assert.EqualError(t, err, "failed")
+		assert.Equal(t, 2, calls)
+		assert.Equal(t, handlerErrors+2, testutil.ToFloat64(eventHandlerErrors.WithLabelValues("test.error")))
+	})
+
+	t.Run("topic can't be used with another payload type", func(t *testing.T) {
+		bus := NewEventBus()
+		_, _ = bus.Subscribe(NewTopic("test.type", vendorRegistered{}), func(payload interface{}) error {
+			return nil
+		})
+		other := NewTopic("test.type", "")
+
+		_, err := bus.Subscribe(other, func(payload interface{}) error {
+			return nil
+		})
+		assert.True(t, errors.Is(err, ErrTopicTypeMismatch))
+
+		err = bus.Publish(other, "")
+		assert.True(t, errors.Is(err, ErrTopicTypeMismatch))
+	})
+}
+
+func TestEventBus_Publish(t *testing.T) {
+	t.Run("payload must match the topic's type", func(t *testing.T) {
+		bus := NewEventBus()
+
+		err := bus.Publish(NewTopic("test.payload", vendorRegistered{}), &vendorRegistered{})
+
+		assert.True(t, errors.Is(err, ErrInvalidPayload))
+	})
+
+	t.Run("publishing without subscribers is ok", func(t *testing.T) {
+		assert.NoError(t, NewEventBus().Publish(NewTopic("test.none", 0), 1))
+	})
+}
+
+func TestEventBus_SubscribeAsync(t *testing.T) {
+	t.Run("events are delivered in order", func(t *testing.T) {
+		bus := NewEventBus()
+		topic := NewTopic("test.async", 0)
+		var received []int
+		s, _ := bus.SubscribeAsync(topic, func(payload interface{}) error {
+			received = append(received, payload.(int))
+			return nil
+		}, 10)
+
+		for i := 0; i < 5; i++ {
+			assert.NoError(t, bus.Publish(topic, i))
+		}
+		s.Unsubscribe()
+
+		assert.Equal(t, []int{0, 1, 2, 3, 4}, received)
+	})
+
+	t.Run("error for invalid queue size", func(t *testing.T) {
+		bus := NewEventBus()
+		topic := NewTopic("test.queue", 0)
+		handler := func(payload interface{}) error {
+			return nil
+		}
+
+		for _, size := range []int{0, -1} {
+			_, err := bus.SubscribeAsync(topic, handler, size)
+
+			assert.True(t, errors.Is(err, ErrInvalidQueueSize))
+		}
+	})
+
+	t.Run("events are dropped when the queue is full", func(t *testing.T) {
+		bus := NewEventBus()
+		topic := NewTopic("test.full", 0)
+		handling := make(chan struct{})
+		release := make(chan struct{})
+		var received []int
+		s, _ := bus.SubscribeAsync(topic, func(payload interface{}) error {
+			if payload.(int) == 0 {
+				close(handling)
+				<-release
+			}
+			received = append(received, payload.(int))
+			return nil
+		}, 1)
+
+		dropped := testutil.ToFloat64(eventsDropped.WithLabelValues("test.full"))
+
+		_ = bus.Publish(topic, 0)
+		<-handling
+		_ = bus.Publish(topic, 1)
+		_ = bus.Publish(topic, 2)
+		close(release)
+		s.Unsubscribe()
+
+		assert.Equal(t, []int{0, 1}, received)
+		assert.Equal(t, dropped+1, testutil.ToFloat64(eventsDropped.WithLabelValues("test.full")))
+	})
+
+	t.Run("returns error on type mismatch", func(t *testing.T) {
+		bus := NewEventBus()
+		_, _ = bus.Subscribe(NewTopic("test.async-type", 0), func(payload interface{}) error {
+			return nil
+		})
+
+		_, err := bus.SubscribeAsync(NewTopic("test.async-type", ""), func(payload interface{}) error {
+			return nil
+		}, 1)
+
+		assert.True(t, errors.Is(err, ErrTopicTypeMismatch))
+	})
+}
+
+func TestSubscription_Unsubscribe(t *testing.T) {
+	t.Run("handler is no longer called", func(t *testing.T) {
+		bus := NewEventBus()
+		topic := NewTopic("test.unsubscribe", 0)
+		calls := 0
+		s, _ := bus.Subscribe(topic, func(payload interface{}) error {
+			calls++
+			return nil
+		})
+
+		s.Unsubscribe()
+		s.Unsubscribe()
+		_ = bus.Publish(topic, 1)
+
+		assert.Equal(t, 0, calls)
+	})
+
+	t.Run("concurrent publish and unsubscribe", func(t *testing.T) {
+		bus := NewEventBus()
+		topic := NewTopic("test.concurrent", 0)
+		wg := sync.WaitGroup{}
+		for i := 0; i < 10; i++ {
+			s, _ := bus.SubscribeAsync(topic, func(payload interface{}) error {
+				return nil
+			}, 1)
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				_ = bus.Publish(topic, 1)
+			}()
+			go func() {
+				defer wg.Done()
+				s.Unsubscribe()
+			}()
+		}
+		wg.Wait()
+	})
+}
+
+func TestEngineControl_Events(t *testing.T) {
+	ctl := NewEngineControl()
+
+	assert.Same(t, ctl.Events(), ctl.Events())
+	assert.NotSame(t, ctl.Events(), NewEngineControl().Events())
+}
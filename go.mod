@@ -3,6 +3,7 @@ module github.com/nuts-foundation/nuts-go-core
 go 1.13
 
 require (
+	github.com/fsnotify/fsnotify v1.4.7
 	github.com/golang/mock v1.4.4
 	github.com/labstack/echo/v4 v4.1.17
 	github.com/prometheus/client_golang v0.9.4
@@ -14,5 +15,5 @@ require (
 	github.com/spf13/viper v1.7.1
 	github.com/stretchr/testify v1.6.1
 	gopkg.in/check.v1 v1.0.0-20190902080502-41f04d3bba15 // indirect
-	gopkg.in/yaml.v2 v2.2.8 // indirect
+	gopkg.in/yaml.v2 v2.2.8
 )
@@ -4,12 +4,16 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
 	"regexp"
 	"strings"
 )
 
 var partyIDPattern = regexp.MustCompile("urn:oid:([0-9\\.]+):(.*)")
 
+// ErrInvalidPartyID is returned when a value can't be parsed as PartyID
+var ErrInvalidPartyID = errors.New("invalid PartyID")
+
 // PartyID is a data type uniquely identifying a party in the Nuts Network.
 // It's represented as a URN-encoded OID: https://www.ietf.org/rfc/rfc8141.txt
 // For example: urn:oid:1.2.3.4:foo
@@ -45,10 +49,41 @@ func (i *PartyID) UnmarshalJSON(bytes []byte) error {
 func ParsePartyID(input string) (PartyID, error) {
 	parts := partyIDPattern.FindStringSubmatch(input)
 	if len(parts) != 3 {
-		return PartyID{}, fmt.Errorf("invalid PartyID: %s", input)
+		return PartyID{}, fmt.Errorf("%w: %s", ErrInvalidPartyID, input)
+	} else if partyID, err := NewPartyID(parts[1], parts[2]); err != nil {
+		return PartyID{}, fmt.Errorf("%w: %v", ErrInvalidPartyID, err)
 	} else {
-		return NewPartyID(parts[1], parts[2])
+		return partyID, nil
+	}
+}
+
+// UnmarshalParam parses a request parameter as PartyID, so echo can bind PartyID fields (e.g. tagged `param:"id"` or `query:"id"`).
+// URL-encoded values (e.g. urn%3Aoid%3A1.2.3%3Afoo) are decoded.
+func (i *PartyID) UnmarshalParam(param string) error {
+	partyID, err := decodePartyID(param)
+	if err != nil {
+		return invalidPartyID(err, "", "")
+	}
+	*i = partyID
+	return nil
+}
+
+// decodePartyID parses the value as PartyID. When it isn't a valid PartyID, it's parsed again after URL-decoding.
+// Values are never decoded twice: a valid PartyID containing an escape sequence is returned as is.
+func decodePartyID(value string) (PartyID, error) {
+	partyID, err := ParsePartyID(value)
+	if err == nil || !strings.Contains(value, "%") {
+		return partyID, err
+	}
+	unescaped, unescapeErr := url.PathUnescape(value)
+	if unescapeErr != nil {
+		return PartyID{}, err
+	}
+	if partyID, unescapedErr := ParsePartyID(unescaped); unescapedErr == nil {
+		return partyID, nil
 	}
+	// report the value as given
+	return PartyID{}, err
 }
 
 // NewPartyID creates a new PartyID
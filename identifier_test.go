@@ -1,6 +1,7 @@
 package core
 
 import (
+	"errors"
 	"encoding/json"
 	"github.com/stretchr/testify/assert"
 	"testing"
@@ -95,6 +96,46 @@ func TestPartyID_String(t *testing.T) {
 	})
 }
 
+func TestParsePartyID(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		partyID, err := ParsePartyID("urn:oid:1.2.3:foo")
+		assert.NoError(t, err)
+		assert.Equal(t, "urn:oid:1.2.3:foo", partyID.String())
+	})
+	t.Run("error - value empty", func(t *testing.T) {
+		_, err := ParsePartyID("urn:oid:1.2.3:")
+		assert.True(t, errors.Is(err, ErrInvalidPartyID))
+		assert.EqualError(t, err, "invalid PartyID: PartyID value is empty")
+	})
+}
+
+func TestPartyID_UnmarshalParam(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		var id PartyID
+		err := id.UnmarshalParam("urn:oid:1.2.3:foo")
+		assert.NoError(t, err)
+		assert.Equal(t, "urn:oid:1.2.3:foo", id.String())
+	})
+	t.Run("ok - URL-encoded", func(t *testing.T) {
+		var id PartyID
+		err := id.UnmarshalParam("urn%3Aoid%3A1.2.3%3Afoo")
+		assert.NoError(t, err)
+		assert.Equal(t, "urn:oid:1.2.3:foo", id.String())
+	})
+	t.Run("ok - escape sequence in value isn't decoded", func(t *testing.T) {
+		var id PartyID
+		err := id.UnmarshalParam("urn:oid:1.2.3:foo%2541")
+		assert.NoError(t, err)
+		assert.Equal(t, "foo%2541", id.Value())
+	})
+	t.Run("error - invalid format", func(t *testing.T) {
+		var id PartyID
+		err := id.UnmarshalParam("foo%3Abar")
+		assert.True(t, errors.Is(err, ErrInvalidPartyID))
+		assert.EqualError(t, err, "invalid PartyID: foo%3Abar")
+	})
+}
+
 func quote(input string) string {
 	return `"` + input + `"`
 }
\ No newline at end of file
@@ -0,0 +1,366 @@
+/*
+ * Nuts go core
+ * Copyright (C) 2020 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrMissingDependency is returned when an engine depends on an engine which is not registered
+var ErrMissingDependency = errors.New("missing engine dependency")
+
+// ErrDependencyCycle is returned when the dependencies between engines form a cycle
+var ErrDependencyCycle = errors.New("engine dependency cycle")
+
+// ErrAlreadyStarted is returned when the engines are started again before they are shut down
+var ErrAlreadyStarted = errors.New("engines already started")
+
+// ErrShutdownTimeout is returned when an engine did not shut down before its deadline
+var ErrShutdownTimeout = errors.New("engine shutdown deadline exceeded")
+
+// StartOrder returns the enabled engines in the order they must be configured and started:
+// every engine comes after the engines it depends on. Engines without a mutual dependency keep their registration order.
+func (ec *EngineControl) StartOrder() ([]*Engine, error) {
+	return orderEngines(ec.Enabled())
+}
+
+// ShutdownOrder returns the enabled engines in the order they must be shut down, which is the reverse of StartOrder.
+func (ec *EngineControl) ShutdownOrder() ([]*Engine, error) {
+	ordered, err := ec.StartOrder()
+	if err != nil {
+		return nil, err
+	}
+	reversed := make([]*Engine, len(ordered))
+	for i, e := range ordered {
+		reversed[len(ordered)-1-i] = e
+	}
+	return reversed, nil
+}
+
+const (
+	unvisited = iota
+	visiting
+	visited
+)
+
+// orderEngines sorts the engines topologically using a depth-first search in registration order.
+func orderEngines(engines []*Engine) ([]*Engine, error) {
+	marks := make(map[*Engine]int, len(engines))
+	ordered := make([]*Engine, 0, len(engines))
+	var path []string
+
+	var visit func(e *Engine) error
+	visit = func(e *Engine) error {
+		switch marks[e] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("%w: %s", ErrDependencyCycle, strings.Join(append(cyclePath(path, e.Name), e.Name), " -> "))
+		}
+		marks[e] = visiting
+		path = append(path, e.Name)
+		for _, dependency := range e.Dependencies {
+			d := findEngine(engines, dependency)
+			if d == nil {
+				return fmt.Errorf("%w: engine %s depends on %s", ErrMissingDependency, e.Name, dependency)
+			}
+			if err := visit(d); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		marks[e] = visited
+		ordered = append(ordered, e)
+		return nil
+	}
+
+	for _, e := range engines {
+		if err := visit(e); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// cyclePath returns the part of the path starting at the engine which closes the cycle
+func cyclePath(path []string, name string) []string {
+	for i, p := range path {
+		if p == name {
+			return path[i:]
+		}
+	}
+	return path
+}
+
+// findEngine returns the engine matching the given Name or ConfigKey, nil if there's no such engine
+func findEngine(engines []*Engine, nameOrKey string) *Engine {
+	for _, e := range engines {
+		if e.Name == nameOrKey || (e.ConfigKey != "" && e.ConfigKey == nameOrKey) {
+			return e
+		}
+	}
+	return nil
+}
+
+const (
+	// ConfigurePhase is the lifecycle phase in which engines check their configuration
+	ConfigurePhase = "configure"
+	// StartPhase is the lifecycle phase in which engines are started
+	StartPhase = "start"
+	// ShutdownPhase is the lifecycle phase in which engines are shut down
+	ShutdownPhase = "shutdown"
+	// ReconfigurePhase is the phase in which engines apply a reloaded configuration
+	ReconfigurePhase = "reconfigure"
+)
+
+// EngineError is the error returned by a single engine during one of the lifecycle phases
+type EngineError struct {
+	// Engine is the name of the engine that failed
+	Engine string
+	// Phase is the lifecycle phase in which the engine failed
+	Phase string
+	// Err is the error returned by the engine
+	Err error
+}
+
+func (e EngineError) Error() string {
+	return fmt.Sprintf("%s of engine %s failed: %v", e.Phase, e.Engine, e.Err)
+}
+
+// Unwrap returns the error returned by the engine
+func (e EngineError) Unwrap() error {
+	return e.Err
+}
+
+// EngineErrors aggregates the errors of multiple engines, in the order they occurred
+type EngineErrors []EngineError
+
+func (e EngineErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Is reports whether any of the aggregated errors matches the target
+func (e EngineErrors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Configure calls Configure on all enabled engines in dependency order, see SelectEngines. Engines that are already
+// configured are skipped. It stops at the first engine returning an error.
+func (ec *EngineControl) Configure() error {
+	ordered, err := ec.StartOrder()
+	if err != nil {
+		return err
+	}
+	for _, e := range ordered {
+		if ec.status(e).State == EngineConfigured {
+			continue
+		}
+		var err error
+		if e.Configure != nil {
+			err = e.Configure()
+		}
+		ec.transitionOrFail(e, EngineConfigured, err)
+		if err != nil {
+			return EngineError{Engine: e.Name, Phase: ConfigurePhase, Err: err}
+		}
+	}
+	return nil
+}
+
+// Start starts all registered engines, see StartContext.
+func (ec *EngineControl) Start() error {
+	return ec.StartContext(context.Background())
+}
+
+// StartContext starts all registered engines in dependency order. When an engine fails to start, the background tasks it
+// started are stopped and the engines that were already started are shut down in reverse order. The returned EngineErrors starts with the error of the failing engine,
+// followed by any errors returned while shutting down the other engines.
+// It returns ErrAlreadyStarted when the engines were started before and haven't been shut down since.
+func (ec *EngineControl) StartContext(ctx context.Context) error {
+	ordered, err := ec.StartOrder()
+	if err != nil {
+		return err
+	}
+	ec.stateMutex.Lock()
+	if ec.running {
+		ec.stateMutex.Unlock()
+		return ErrAlreadyStarted
+	}
+	ec.running = true
+	ec.stateMutex.Unlock()
+
+	for _, e := range ordered {
+		err := e.start(ctx)
+		ec.transitionOrFail(e, EngineStarted, err)
+		if err != nil {
+			errs := EngineErrors{{Engine: e.Name, Phase: StartPhase, Err: err}}
+			if err := ec.stopTasks(e); err != nil {
+				errs = append(errs, EngineError{Engine: e.Name, Phase: ShutdownPhase, Err: err})
+			}
+			return append(errs, ec.shutdownStarted(context.Background())...)
+		}
+		ec.stateMutex.Lock()
+		ec.started = append(ec.started, e)
+		ec.stateMutex.Unlock()
+	}
+	return nil
+}
+
+// Shutdown shuts down all started engines, see ShutdownContext.
+func (ec *EngineControl) Shutdown() error {
+	return ec.ShutdownContext(context.Background())
+}
+
+// ShutdownContext shuts down all engines started by Start in reverse order. All engines are shut down, even when one of them fails.
+// Every engine gets its own deadline: the engine's ShutdownTimeout or, when not set, the ShutdownTimeout of the EngineControl.
+// An engine which doesn't return before its deadline or before the given context is done is reported with ErrShutdownTimeout
+// and left behind, so the remaining engines can still be shut down. The errors of the failing engines are returned as EngineErrors.
+func (ec *EngineControl) ShutdownContext(ctx context.Context) error {
+	if errs := ec.shutdownStarted(ctx); len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (ec *EngineControl) shutdownStarted(ctx context.Context) EngineErrors {
+	ec.stateMutex.Lock()
+	started := ec.started
+	ec.started = nil
+	ec.stateMutex.Unlock()
+
+	var errs EngineErrors
+	for i := len(started) - 1; i >= 0; i-- {
+		e := started[i]
+		err := ec.shutdownEngine(ctx, e, ec.shutdownTimeout(e))
+		ec.transitionOrFail(e, EngineStopped, err)
+		if err != nil {
+			if errors.Is(err, ErrShutdownTimeout) {
+				log.Warnf("Engine %s did not shut down in time: %v", e.Name, err)
+			}
+			errs = append(errs, EngineError{Engine: e.Name, Phase: ShutdownPhase, Err: err})
+		}
+	}
+	ec.stateMutex.Lock()
+	ec.running = false
+	ec.stateMutex.Unlock()
+	return errs
+}
+
+// SetShutdownTimeout sets the default time an engine may take to shut down, see ShutdownTimeout. It's safe for concurrent use.
+func (ec *EngineControl) SetShutdownTimeout(timeout time.Duration) {
+	ec.stateMutex.Lock()
+	defer ec.stateMutex.Unlock()
+	ec.ShutdownTimeout = timeout
+}
+
+// defaultShutdownTimeout returns ShutdownTimeout, guarded against concurrent calls of SetShutdownTimeout
+func (ec *EngineControl) defaultShutdownTimeout() time.Duration {
+	ec.stateMutex.RLock()
+	defer ec.stateMutex.RUnlock()
+	return ec.ShutdownTimeout
+}
+
+// shutdownTimeout returns the time the engine may take to shut down: its own ShutdownTimeout or the default of the EngineControl
+func (ec *EngineControl) shutdownTimeout(e *Engine) time.Duration {
+	if e.ShutdownTimeout > 0 {
+		return e.ShutdownTimeout
+	}
+	return ec.defaultShutdownTimeout()
+}
+
+// shutdownEngine calls the shutdown hook of the engine and stops its background tasks, until the timeout (if > 0) expires or the context is done.
+// The background tasks are stopped even when the shutdown hook fails.
+func (ec *EngineControl) shutdownEngine(ctx context.Context, e *Engine, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	err := e.shutdown(ctx)
+	if stopErr := ec.stopSupervisor(ctx, e); stopErr != nil {
+		if err == nil {
+			return stopErr
+		}
+		return fmt.Errorf("%w (%v)", err, stopErr)
+	}
+	return err
+}
+
+// stopTasks stops the background tasks of an engine that failed to start, within the engine's shutdown timeout
+func (ec *EngineControl) stopTasks(e *Engine) error {
+	ctx := context.Background()
+	if timeout := ec.shutdownTimeout(e); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return ec.stopSupervisor(ctx, e)
+}
+
+func (e *Engine) start(ctx context.Context) error {
+	if e.StartContext != nil {
+		return e.StartContext(ctx)
+	}
+	if e.Start != nil {
+		return e.Start()
+	}
+	return nil
+}
+
+// shutdown calls the shutdown hook of the engine and waits for it to return until the context is done.
+func (e *Engine) shutdown(ctx context.Context) error {
+	var hook func(ctx context.Context) error
+	switch {
+	case e.ShutdownContext != nil:
+		hook = e.ShutdownContext
+	case e.Shutdown != nil:
+		hook = func(_ context.Context) error {
+			return e.Shutdown()
+		}
+	default:
+		return nil
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		result <- hook(ctx)
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("%w: %v", ErrShutdownTimeout, ctx.Err())
+	}
+}
@@ -0,0 +1,394 @@
+/*
+ * Nuts go core
+ * Copyright (C) 2020 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package core
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func engineNames(engines []*Engine) []string {
+	var names []string
+	for _, e := range engines {
+		names = append(names, e.Name)
+	}
+	return names
+}
+
+func TestEngineControl_StartOrder(t *testing.T) {
+	t.Run("keeps registration order without dependencies", func(t *testing.T) {
+		ctl := EngineControl{}
+		ctl.Register(&Engine{Name: "A"})
+		ctl.Register(&Engine{Name: "B"})
+
+		ordered, err := ctl.StartOrder()
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"A", "B"}, engineNames(ordered))
+	})
+
+	t.Run("dependencies come first, by name or config key", func(t *testing.T) {
+		ctl := EngineControl{}
+		ctl.Register(&Engine{Name: "Consent", Dependencies: []string{"Registry"}})
+		ctl.Register(&Engine{Name: "Registry", Dependencies: []string{"crypto"}})
+		ctl.Register(&Engine{Name: "Crypto", ConfigKey: "crypto"})
+
+		ordered, err := ctl.StartOrder()
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"Crypto", "Registry", "Consent"}, engineNames(ordered))
+	})
+
+	t.Run("error on missing dependency", func(t *testing.T) {
+		ctl := EngineControl{}
+		ctl.Register(&Engine{Name: "Registry", Dependencies: []string{"Crypto"}})
+
+		_, err := ctl.StartOrder()
+
+		assert.True(t, errors.Is(err, ErrMissingDependency))
+		assert.Equal(t, "missing engine dependency: engine Registry depends on Crypto", err.Error())
+	})
+
+	t.Run("error on cycle", func(t *testing.T) {
+		ctl := EngineControl{}
+		ctl.Register(&Engine{Name: "A", Dependencies: []string{"B"}})
+		ctl.Register(&Engine{Name: "B", Dependencies: []string{"C"}})
+		ctl.Register(&Engine{Name: "C", Dependencies: []string{"B"}})
+
+		_, err := ctl.StartOrder()
+
+		assert.True(t, errors.Is(err, ErrDependencyCycle))
+		assert.Equal(t, "engine dependency cycle: B -> C -> B", err.Error())
+	})
+
+	t.Run("error on self dependency", func(t *testing.T) {
+		ctl := EngineControl{}
+		ctl.Register(&Engine{Name: "A", Dependencies: []string{"A"}})
+
+		_, err := ctl.StartOrder()
+
+		assert.True(t, errors.Is(err, ErrDependencyCycle))
+	})
+}
+
+func TestEngineControl_ShutdownOrder(t *testing.T) {
+	t.Run("reverse of start order", func(t *testing.T) {
+		ctl := EngineControl{}
+		ctl.Register(&Engine{Name: "Consent", Dependencies: []string{"Crypto"}})
+		ctl.Register(&Engine{Name: "Crypto"})
+
+		ordered, err := ctl.ShutdownOrder()
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"Consent", "Crypto"}, engineNames(ordered))
+	})
+
+	t.Run("error on cycle", func(t *testing.T) {
+		ctl := EngineControl{}
+		ctl.Register(&Engine{Name: "A", Dependencies: []string{"A"}})
+
+		_, err := ctl.ShutdownOrder()
+
+		assert.Error(t, err)
+	})
+}
+
+// recordingEngine returns an engine which appends its lifecycle calls to calls and fails in the given phase
+func recordingEngine(name string, calls *[]string, failIn string) *Engine {
+	call := func(phase string) error {
+		*calls = append(*calls, phase+" "+name)
+		if phase == failIn {
+			return errors.New(phase + " failed")
+		}
+		return nil
+	}
+	return &Engine{
+		Name:      name,
+		Configure: func() error { return call(ConfigurePhase) },
+		Start:     func() error { return call(StartPhase) },
+		Shutdown:  func() error { return call(ShutdownPhase) },
+	}
+}
+
+func TestEngineControl_Configure(t *testing.T) {
+	t.Run("configures all engines in dependency order", func(t *testing.T) {
+		var calls []string
+		ctl := EngineControl{}
+		b := recordingEngine("B", &calls, "")
+		b.Dependencies = []string{"A"}
+		ctl.Register(b)
+		ctl.Register(recordingEngine("A", &calls, ""))
+		ctl.Register(&Engine{Name: "no hooks"})
+
+		err := ctl.Configure()
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"configure A", "configure B"}, calls)
+	})
+
+	t.Run("stops at first failing engine", func(t *testing.T) {
+		var calls []string
+		ctl := EngineControl{}
+		ctl.Register(recordingEngine("A", &calls, ConfigurePhase))
+		ctl.Register(recordingEngine("B", &calls, ""))
+
+		err := ctl.Configure()
+
+		assert.Equal(t, "configure of engine A failed: configure failed", err.Error())
+		assert.Equal(t, []string{"configure A"}, calls)
+	})
+
+	t.Run("skips configured and disabled engines", func(t *testing.T) {
+		var calls []string
+		ctl := NewEngineControl()
+		ctl.Register(recordingEngine("A", &calls, ""))
+		b := recordingEngine("B", &calls, "")
+		b.ConfigKey = "b"
+		ctl.Register(b)
+		assert.NoError(t, ctl.Configure())
+		ctl.Register(recordingEngine("C", &calls, ""))
+		d := recordingEngine("D", &calls, "")
+		d.ConfigKey = "d"
+		ctl.Register(d)
+		cfg := NewNutsGlobalConfig()
+		cfg.v.Set(disabledEnginesFlag, []string{"d"})
+		assert.NoError(t, ctl.SelectEngines(cfg))
+
+		err := ctl.Configure()
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"configure A", "configure B", "configure C"}, calls)
+	})
+
+	t.Run("error on cycle", func(t *testing.T) {
+		ctl := EngineControl{}
+		ctl.Register(&Engine{Name: "A", Dependencies: []string{"A"}})
+
+		assert.True(t, errors.Is(ctl.Configure(), ErrDependencyCycle))
+	})
+}
+
+func TestEngineControl_Start(t *testing.T) {
+	t.Run("starts all engines and shuts them down in reverse order", func(t *testing.T) {
+		var calls []string
+		ctl := EngineControl{}
+		ctl.Register(recordingEngine("A", &calls, ""))
+		ctl.Register(&Engine{Name: "no hooks"})
+		ctl.Register(recordingEngine("B", &calls, ""))
+
+		assert.NoError(t, ctl.Start())
+		assert.NoError(t, ctl.Shutdown())
+		assert.Equal(t, []string{"start A", "start B", "shutdown B", "shutdown A"}, calls)
+	})
+
+	t.Run("rolls back started engines when an engine fails to start", func(t *testing.T) {
+		var calls []string
+		ctl := EngineControl{}
+		ctl.Register(recordingEngine("A", &calls, ""))
+		ctl.Register(recordingEngine("B", &calls, ""))
+		ctl.Register(recordingEngine("C", &calls, StartPhase))
+		ctl.Register(recordingEngine("D", &calls, ""))
+
+		err := ctl.Start()
+
+		assert.Equal(t, "start of engine C failed: start failed", err.Error())
+		assert.Equal(t, []string{"start A", "start B", "start C", "shutdown B", "shutdown A"}, calls)
+	})
+
+	t.Run("rollback errors are aggregated", func(t *testing.T) {
+		var calls []string
+		ctl := EngineControl{}
+		ctl.Register(recordingEngine("A", &calls, ShutdownPhase))
+		ctl.Register(recordingEngine("B", &calls, StartPhase))
+
+		err := ctl.Start()
+
+		assert.Equal(t, "start of engine B failed: start failed; shutdown of engine A failed: shutdown failed", err.Error())
+		errs := err.(EngineErrors)
+		assert.Len(t, errs, 2)
+		assert.Equal(t, "B", errs[0].Engine)
+	})
+
+	t.Run("error when already started", func(t *testing.T) {
+		var calls []string
+		ctl := EngineControl{}
+		ctl.Register(recordingEngine("A", &calls, ""))
+		assert.NoError(t, ctl.Start())
+
+		err := ctl.Start()
+
+		assert.True(t, errors.Is(err, ErrAlreadyStarted))
+		assert.NoError(t, ctl.Shutdown())
+		assert.Equal(t, []string{"start A", "shutdown A"}, calls)
+	})
+
+	t.Run("can be started again after shutdown", func(t *testing.T) {
+		var calls []string
+		ctl := EngineControl{}
+		ctl.Register(recordingEngine("A", &calls, ""))
+		_ = ctl.Start()
+		_ = ctl.Shutdown()
+
+		assert.NoError(t, ctl.Start())
+		assert.NoError(t, ctl.Shutdown())
+		assert.Equal(t, []string{"start A", "shutdown A", "start A", "shutdown A"}, calls)
+	})
+
+	t.Run("error on cycle", func(t *testing.T) {
+		ctl := EngineControl{}
+		ctl.Register(&Engine{Name: "A", Dependencies: []string{"A"}})
+
+		assert.True(t, errors.Is(ctl.Start(), ErrDependencyCycle))
+	})
+}
+
+func TestEngineControl_Shutdown(t *testing.T) {
+	t.Run("shuts down all engines even when one fails", func(t *testing.T) {
+		var calls []string
+		ctl := EngineControl{}
+		ctl.Register(recordingEngine("A", &calls, ""))
+		ctl.Register(recordingEngine("B", &calls, ShutdownPhase))
+		_ = ctl.Start()
+
+		err := ctl.Shutdown()
+
+		assert.Equal(t, "shutdown of engine B failed: shutdown failed", err.Error())
+		assert.Equal(t, []string{"start A", "start B", "shutdown B", "shutdown A"}, calls)
+	})
+
+	t.Run("no-op when nothing was started", func(t *testing.T) {
+		var calls []string
+		ctl := EngineControl{}
+		ctl.Register(recordingEngine("A", &calls, ""))
+
+		assert.NoError(t, ctl.Shutdown())
+		assert.Empty(t, calls)
+	})
+}
+
+func TestEngineErrors_Is(t *testing.T) {
+	errs := EngineErrors{{Engine: "A", Err: ErrMissingDependency}}
+
+	assert.True(t, errors.Is(errs, ErrMissingDependency))
+	assert.False(t, errors.Is(errs, ErrDependencyCycle))
+}
+
+func TestEngineControl_StartContext(t *testing.T) {
+	t.Run("StartContext hook takes precedence and receives the context", func(t *testing.T) {
+		type key struct{}
+		var received interface{}
+		ctl := EngineControl{}
+		ctl.Register(&Engine{
+			Name: "A",
+			Start: func() error {
+				return errors.New("legacy hook called")
+			},
+			StartContext: func(ctx context.Context) error {
+				received = ctx.Value(key{})
+				return nil
+			},
+		})
+
+		err := ctl.StartContext(context.WithValue(context.Background(), key{}, "value"))
+
+		assert.NoError(t, err)
+		assert.Equal(t, "value", received)
+	})
+}
+
+func TestEngineControl_ShutdownContext(t *testing.T) {
+	blocking := func(name string, shutdownTimeout time.Duration) *Engine {
+		return &Engine{
+			Name:            name,
+			ShutdownTimeout: shutdownTimeout,
+			ShutdownContext: func(ctx context.Context) error {
+				<-ctx.Done()
+				// ignores the context and returns too late
+				time.Sleep(50 * time.Millisecond)
+				return nil
+			},
+		}
+	}
+
+	t.Run("ShutdownContext hook takes precedence", func(t *testing.T) {
+		var calls []string
+		ctl := EngineControl{}
+		e := recordingEngine("A", &calls, "")
+		e.ShutdownContext = func(ctx context.Context) error {
+			calls = append(calls, "shutdown context A")
+			return nil
+		}
+		ctl.Register(e)
+		_ = ctl.Start()
+
+		assert.NoError(t, ctl.ShutdownContext(context.Background()))
+		assert.Equal(t, []string{"start A", "shutdown context A"}, calls)
+	})
+
+	t.Run("reports engine exceeding global deadline and continues", func(t *testing.T) {
+		var calls []string
+		ctl := EngineControl{ShutdownTimeout: 10 * time.Millisecond}
+		ctl.Register(recordingEngine("A", &calls, ""))
+		ctl.Register(blocking("B", 0))
+		_ = ctl.Start()
+
+		err := ctl.ShutdownContext(context.Background())
+
+		assert.True(t, errors.Is(err, ErrShutdownTimeout))
+		errs := err.(EngineErrors)
+		assert.Len(t, errs, 1)
+		assert.Equal(t, "B", errs[0].Engine)
+		assert.Equal(t, []string{"start A", "shutdown A"}, calls)
+	})
+
+	t.Run("per-engine deadline overrides global deadline", func(t *testing.T) {
+		ctl := EngineControl{ShutdownTimeout: time.Hour}
+		ctl.Register(blocking("A", 10*time.Millisecond))
+		_ = ctl.Start()
+
+		err := ctl.ShutdownContext(context.Background())
+
+		assert.True(t, errors.Is(err, ErrShutdownTimeout))
+	})
+
+	t.Run("legacy Shutdown hook is bound by the deadline", func(t *testing.T) {
+		ctl := EngineControl{}
+		done := make(chan struct{})
+		defer close(done)
+		ctl.Register(&Engine{
+			Name: "A",
+			Shutdown: func() error {
+				<-done
+				return nil
+			},
+		})
+		_ = ctl.Start()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		err := ctl.ShutdownContext(ctx)
+
+		assert.Equal(t, "shutdown of engine A failed: engine shutdown deadline exceeded: context deadline exceeded", err.Error())
+	})
+}
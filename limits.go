@@ -0,0 +1,244 @@
+/*
+ * Nuts go core
+ * Copyright (C) 2020 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const httpRateLimitFlag = "http.ratelimit"
+const httpRateLimitBurstFlag = "http.ratelimitburst"
+const httpMaxBodySizeFlag = "http.maxbodysize"
+const httpReadTimeoutFlag = "http.readtimeout"
+const httpWriteTimeoutFlag = "http.writetimeout"
+
+// httpEngineLimitsKey is the config key holding the limits of the routes of a single engine, by ConfigKey,
+// e.g. http.engines.registry.maxbodysize
+const httpEngineLimitsKey = "http.engines"
+
+const (
+	rateLimitRejection = "ratelimit"
+	bodySizeRejection  = "bodysize"
+)
+
+// idleBucketTimeout is the time after which the rate limit state of an idle client is removed
+const idleBucketTimeout = 10 * time.Minute
+
+var httpRequestsRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: NutsMetricsPrefix + "http_requests_rejected_total",
+	Help: "Number of HTTP requests rejected because of a limit, per engine and reason (ratelimit or bodysize).",
+}, []string{"engine", "reason"})
+
+// ErrInvalidLimits is returned when the limits of the HTTP server are invalid
+var ErrInvalidLimits = errors.New("invalid HTTP limits")
+
+// ErrRateLimitExceeded is returned when a client sends more requests than allowed by the rate limit
+var ErrRateLimitExceeded = NewError("rate limit exceeded", true)
+
+// ErrBodyTooLarge is returned when a request body exceeds the maximum body size
+var ErrBodyTooLarge = NewError("request body too large", true)
+
+// LimitOptions holds the limits applied to the requests for the routes of an engine
+type LimitOptions struct {
+	// RateLimit is the maximum number of requests per second per client (authenticated principal or IP address), 0 means unlimited
+	RateLimit float64
+	// RateLimitBurst is the number of requests a client may send at once, when 0 it's RateLimit rounded up
+	RateLimitBurst int
+	// MaxBodySize is the maximum size of a request body in bytes, 0 means unlimited
+	MaxBodySize int64
+}
+
+// TimeoutOptions holds the timeouts of the connections to the node's HTTP server, 0 means no timeout
+type TimeoutOptions struct {
+	// Read is the maximum duration for reading a request, including its body
+	Read time.Duration
+	// Write is the maximum duration from the end of reading the request headers until the end of writing the response
+	Write time.Duration
+}
+
+func (o LimitOptions) validate() error {
+	if o.RateLimit < 0 || o.RateLimitBurst < 0 || o.MaxBodySize < 0 {
+		return fmt.Errorf("%w: limits can't be negative: %+v", ErrInvalidLimits, o)
+	}
+	return nil
+}
+
+func (o LimitOptions) burst() int {
+	if o.RateLimitBurst > 0 {
+		return o.RateLimitBurst
+	}
+	return int(math.Ceil(o.RateLimit))
+}
+
+// engineLimits returns the middleware applying the configured limits to the routes of each of the engines.
+// Engines with the same limits share the rate limit of a client.
+func engineLimits(config NutsConfigValues, engines []*Engine) (map[*Engine]echo.MiddlewareFunc, error) {
+	if err := config.Limits("").validate(); err != nil {
+		return nil, err
+	}
+	limiters := map[LimitOptions]*rateLimiter{}
+	middleware := make(map[*Engine]echo.MiddlewareFunc, len(engines))
+	for _, engine := range engines {
+		options := config.Limits(engine.ConfigKey)
+		if err := options.validate(); err != nil {
+			return nil, fmt.Errorf("engine %s: %w", engine.Name, err)
+		}
+		limiter, ok := limiters[options]
+		if !ok {
+			limiter = newRateLimiter(options)
+			limiters[options] = limiter
+		}
+		middleware[engine] = limitsMiddleware(engine.Name, options, limiter)
+	}
+	return middleware, nil
+}
+
+// limitsMiddleware returns the middleware applying the limits to the routes of the engine
+func limitsMiddleware(engine string, options LimitOptions, limiter *rateLimiter) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if limiter != nil {
+				if ok, retryAfter := limiter.allow(clientIdentity(c), time.Now()); !ok {
+					httpRequestsRejected.WithLabelValues(engine, rateLimitRejection).Inc()
+					return &ProblemError{Err: ErrRateLimitExceeded, Status: http.StatusTooManyRequests, RetryAfter: retryAfter}
+				}
+			}
+			if options.MaxBodySize > 0 {
+				req := c.Request()
+				if req.ContentLength > options.MaxBodySize {
+					httpRequestsRejected.WithLabelValues(engine, bodySizeRejection).Inc()
+					return bodyTooLarge(options.MaxBodySize)
+				}
+				req.Body = newLimitedBody(req.Body, engine, options.MaxBodySize)
+			}
+			return next(c)
+		}
+	}
+}
+
+func bodyTooLarge(max int64) error {
+	err := NewProblemError(ErrBodyTooLarge, http.StatusRequestEntityTooLarge, "")
+	err.Detail = fmt.Sprintf("request body exceeds %d bytes", max)
+	return err
+}
+
+// clientIdentity returns the key the rate limit of the request's client is tracked by: its principal or IP address
+func clientIdentity(c echo.Context) string {
+	if principal := PrincipalFrom(c.Request().Context()); principal != nil {
+		return principal.Method + ":" + principal.Name
+	}
+	return "ip:" + c.RealIP()
+}
+
+// limitedBody fails reading a body beyond the maximum size, for requests without or with an incorrect Content-Length
+type limitedBody struct {
+	io.ReadCloser
+	engine    string
+	max       int64
+	remaining int64
+	exceeded  bool
+}
+
+func newLimitedBody(body io.ReadCloser, engine string, max int64) *limitedBody {
+	return &limitedBody{ReadCloser: body, engine: engine, max: max, remaining: max}
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.exceeded {
+		return 0, bodyTooLarge(b.max)
+	}
+	// read one byte more than remaining, to detect the body exceeds the maximum size
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) > b.remaining {
+		b.exceeded = true
+		httpRequestsRejected.WithLabelValues(b.engine, bodySizeRejection).Inc()
+		return int(b.remaining), bodyTooLarge(b.max)
+	}
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// rateLimiter is a token bucket rate limiter per client
+type rateLimiter struct {
+	rate      float64
+	burst     float64
+	mutex     sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter creates a rateLimiter for the options, nil when the options don't limit the rate
+func newRateLimiter(options LimitOptions) *rateLimiter {
+	if options.RateLimit <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: options.RateLimit, burst: float64(options.burst()), buckets: map[string]*bucket{}}
+}
+
+// allow takes a token from the client's bucket. When the bucket is empty, it returns the time until a token is available.
+func (l *rateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.sweep(now)
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep removes the buckets of idle clients, so the number of buckets doesn't grow unbounded. Caller must hold the lock.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < idleBucketTimeout {
+		return
+	}
+	for client, b := range l.buckets {
+		if now.Sub(b.last) >= idleBucketTimeout {
+			delete(l.buckets, client)
+		}
+	}
+	l.lastSweep = now
+}
@@ -0,0 +1,243 @@
+/*
+ * Nuts go core
+ * Copyright (C) 2020 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package core
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// limitsEngine returns an engine with the given name and ConfigKey, which echoes the request body at POST /<name>
+func limitsEngine(name string) *Engine {
+	return &Engine{Name: name, ConfigKey: name, Routes: func(router EchoRouter) {
+		router.POST("/"+name, func(c echo.Context) error {
+			body, err := ioutil.ReadAll(c.Request().Body)
+			if err != nil {
+				return err
+			}
+			return c.String(http.StatusOK, string(body))
+		})
+	}}
+}
+
+func post(server *HTTPServer, path string, body string, contentLength int64) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.ContentLength = contentLength
+	rec := httptest.NewRecorder()
+	server.Echo().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHTTPServer_Limits(t *testing.T) {
+	t.Run("requests exceeding the rate limit are rejected", func(t *testing.T) {
+		cfg := NewNutsGlobalConfig()
+		cfg.v.Set(httpRateLimitFlag, 0.5)
+		server := newTestServer(t, cfg, limitsEngine("ratelimited"))
+
+		rejected := httpRequestsRejected.WithLabelValues("ratelimited", rateLimitRejection)
+		before := testutil.ToFloat64(rejected)
+
+		assert.Equal(t, http.StatusOK, post(server, "/ratelimited", "", 0).Code)
+		rec := post(server, "/ratelimited", "", 0)
+
+		assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+		assert.Equal(t, "2", rec.Header().Get("Retry-After"))
+		assert.Equal(t, "rate limit exceeded", problemBody(t, rec)["detail"])
+		assert.Equal(t, before+1, testutil.ToFloat64(rejected))
+	})
+
+	t.Run("body exceeding the maximum size is rejected", func(t *testing.T) {
+		cfg := NewNutsGlobalConfig()
+		cfg.v.Set(httpMaxBodySizeFlag, 4)
+		server := newTestServer(t, cfg, limitsEngine("bodysize"))
+
+		rejected := httpRequestsRejected.WithLabelValues("bodysize", bodySizeRejection)
+		before := testutil.ToFloat64(rejected)
+
+		assert.Equal(t, "1234", post(server, "/bodysize", "1234", 4).Body.String())
+		rec := post(server, "/bodysize", "12345", 5)
+
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+		assert.Equal(t, "request body exceeds 4 bytes", problemBody(t, rec)["detail"])
+		assert.Equal(t, before+1, testutil.ToFloat64(rejected))
+	})
+
+	t.Run("body without content length exceeding the maximum size is rejected", func(t *testing.T) {
+		cfg := NewNutsGlobalConfig()
+		cfg.v.Set(httpMaxBodySizeFlag, 4)
+		server := newTestServer(t, cfg, limitsEngine("chunked"))
+
+		rejected := httpRequestsRejected.WithLabelValues("chunked", bodySizeRejection)
+		before := testutil.ToFloat64(rejected)
+
+		assert.Equal(t, "1234", post(server, "/chunked", "1234", -1).Body.String())
+		rec := post(server, "/chunked", "12345", -1)
+
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+		assert.Equal(t, before+1, testutil.ToFloat64(rejected))
+	})
+
+	t.Run("engine limits override the global limits", func(t *testing.T) {
+		cfg := NewNutsGlobalConfig()
+		cfg.v.Set(httpMaxBodySizeFlag, 4)
+		cfg.v.Set("http.engines.large.maxbodysize", 8)
+		server := newTestServer(t, cfg, limitsEngine("small"), limitsEngine("large"))
+
+		assert.Equal(t, http.StatusRequestEntityTooLarge, post(server, "/small", "12345", 5).Code)
+		assert.Equal(t, http.StatusOK, post(server, "/large", "12345", 5).Code)
+	})
+
+	t.Run("forwarded IP addresses don't bypass the rate limit", func(t *testing.T) {
+		cfg := NewNutsGlobalConfig()
+		cfg.v.Set(httpRateLimitFlag, 0.5)
+		server := newTestServer(t, cfg, limitsEngine("forwarded"))
+		serve := func(forwardedFor string) int {
+			req := httptest.NewRequest(http.MethodPost, "/forwarded", nil)
+			req.Header.Set(echo.HeaderXForwardedFor, forwardedFor)
+			rec := httptest.NewRecorder()
+			server.Echo().ServeHTTP(rec, req)
+			return rec.Code
+		}
+
+		assert.Equal(t, http.StatusOK, serve("10.0.0.1"))
+		assert.Equal(t, http.StatusTooManyRequests, serve("10.0.0.2"))
+	})
+
+	t.Run("requests with invalid credentials are rate limited", func(t *testing.T) {
+		cfg := NewNutsGlobalConfig()
+		cfg.v.Set(httpRateLimitFlag, 0.5)
+		server := newTestServer(t, cfg, authEngine())
+		server.Authentication().Add(NewTokenAuthenticator(map[string]string{"secret": "alice"}))
+
+		assert.Equal(t, http.StatusUnauthorized, serveAuth(server, "/protected", APIKeyHeader, "guess").Code)
+		assert.Equal(t, http.StatusTooManyRequests, serveAuth(server, "/protected", APIKeyHeader, "guess").Code)
+		assert.Equal(t, http.StatusOK, serveAuth(server, "/protected", APIKeyHeader, "secret").Code)
+	})
+
+	t.Run("route middleware can't read beyond the maximum body size", func(t *testing.T) {
+		cfg := NewNutsGlobalConfig()
+		cfg.v.Set(httpMaxBodySizeFlag, 4)
+		var readErr error
+		server := newTestServer(t, cfg, &Engine{Name: "a", Routes: func(router EchoRouter) {
+			router.POST("/a", StatusOK, func(next echo.HandlerFunc) echo.HandlerFunc {
+				return func(c echo.Context) error {
+					_, readErr = ioutil.ReadAll(c.Request().Body)
+					return next(c)
+				}
+			})
+		}})
+
+		post(server, "/a", "12345", -1)
+
+		assert.True(t, errors.Is(readErr, ErrBodyTooLarge))
+	})
+
+	t.Run("error on invalid limits", func(t *testing.T) {
+		cfg := NewNutsGlobalConfig()
+		cfg.v.Set("http.engines.a.ratelimit", -1)
+		ctl := NewEngineControl()
+		ctl.Register(&Engine{Name: "a", ConfigKey: "a"})
+
+		_, err := NewHTTPServer(ctl, cfg)
+
+		assert.True(t, errors.Is(err, ErrInvalidLimits))
+		assert.Contains(t, err.Error(), "engine a")
+	})
+
+	t.Run("timeouts are applied", func(t *testing.T) {
+		cfg := runnerConfig("localhost:0")
+		cfg.v.Set(httpReadTimeoutFlag, time.Second)
+		cfg.v.Set(httpWriteTimeoutFlag, 2*time.Second)
+		server := newTestServer(t, cfg)
+
+		if !assert.NoError(t, server.Start()) {
+			return
+		}
+		defer server.Shutdown(context.Background())
+
+		assert.Equal(t, time.Second, server.servers[0].ReadTimeout)
+		assert.Equal(t, 2*time.Second, server.servers[0].WriteTimeout)
+	})
+}
+
+func TestRateLimiter_allow(t *testing.T) {
+	now := time.Now()
+
+	t.Run("allows a burst and refills at the rate", func(t *testing.T) {
+		l := newRateLimiter(LimitOptions{RateLimit: 2, RateLimitBurst: 3})
+
+		for i := 0; i < 3; i++ {
+			ok, _ := l.allow("a", now)
+			assert.True(t, ok)
+		}
+		ok, retryAfter := l.allow("a", now)
+		assert.False(t, ok)
+		assert.Equal(t, 500*time.Millisecond, retryAfter)
+
+		ok, _ = l.allow("a", now.Add(500*time.Millisecond))
+		assert.True(t, ok)
+	})
+
+	t.Run("clients are limited separately", func(t *testing.T) {
+		l := newRateLimiter(LimitOptions{RateLimit: 1})
+
+		ok, _ := l.allow("a", now)
+		assert.True(t, ok)
+		ok, _ = l.allow("b", now)
+		assert.True(t, ok)
+		ok, _ = l.allow("a", now)
+		assert.False(t, ok)
+	})
+
+	t.Run("idle clients are removed", func(t *testing.T) {
+		l := newRateLimiter(LimitOptions{RateLimit: 1})
+		l.allow("a", now)
+		l.allow("b", now.Add(idleBucketTimeout))
+
+		l.allow("b", now.Add(2*idleBucketTimeout))
+
+		assert.Len(t, l.buckets, 1)
+	})
+
+	t.Run("no limiter without rate limit", func(t *testing.T) {
+		assert.Nil(t, newRateLimiter(LimitOptions{MaxBodySize: 1}))
+	})
+}
+
+func TestNutsGlobalConfig_Limits(t *testing.T) {
+	cfg := NewNutsGlobalConfig()
+	cfg.v.Set(httpRateLimitFlag, 10)
+	cfg.v.Set(httpMaxBodySizeFlag, 1024)
+	cfg.v.Set("http.engines.registry.ratelimit", 5)
+
+	assert.Equal(t, LimitOptions{RateLimit: 10, MaxBodySize: 1024}, cfg.Limits(""))
+	assert.Equal(t, LimitOptions{RateLimit: 5, MaxBodySize: 1024}, cfg.Limits("registry"))
+	assert.Equal(t, LimitOptions{RateLimit: 10, MaxBodySize: 1024}, cfg.Limits("crypto"))
+}
@@ -20,19 +20,48 @@
 package core
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/labstack/echo/v4"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 const NutsMetricsPrefix = "nuts_"
 
+var httpLabels = []string{"engine", "method", "route", "status"}
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: NutsMetricsPrefix + "http_requests_total",
+		Help: "Number of HTTP requests per engine, route and status class.",
+	}, httpLabels)
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    NutsMetricsPrefix + "http_request_duration_seconds",
+		Help:    "Duration of HTTP requests per engine, route and status class.",
+		Buckets: prometheus.DefBuckets,
+	}, httpLabels)
+	httpResponseSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    NutsMetricsPrefix + "http_response_size_bytes",
+		Help:    "Size of HTTP response bodies per engine, route and status class.",
+		Buckets: prometheus.ExponentialBuckets(100, 10, 6),
+	}, httpLabels)
+	httpRequestsInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: NutsMetricsPrefix + "http_requests_in_flight",
+		Help: "Number of HTTP requests being served per engine and route.",
+	}, []string{"engine", "method", "route"})
+)
+
 // NewMetricsEngine creates a new Engine for exposing prometheus metrics via http.
 // Metrics are exposed on /metrics, by default the GoCollector and ProcessCollector are enabled.
 func NewMetricsEngine() *Engine {
 	return &Engine{
-		Name:      "Metrics",
-		Configure: configure,
+		Name:        "Metrics",
+		ConfigKey:   "metrics",
+		AdminRoutes: true,
+		Configure:   configure,
 		Routes: func(router EchoRouter) {
 			router.GET("/metrics", echo.WrapHandler(promhttp.Handler()))
 		},
@@ -55,3 +84,39 @@ func configure() error {
 
 	return nil
 }
+
+// HTTPMetrics returns an echo middleware that records the count, duration and response size of requests and the number of
+// requests in flight. Requests are labelled with the engine owning the route (according to the given route table), the
+// method, the route template (e.g. /registry/vendors/:id) and the status class (e.g. 2xx). Requests for routes not in
+// the table are recorded without engine and route. Errors returned by the handler
+// are passed to the echo error handler, so the status of the error response is recorded.
+func HTTPMetrics(routes []RouteInfo) echo.MiddlewareFunc {
+	owners := make(map[string]string, len(routes))
+	for _, r := range routes {
+		owners[r.Method+" "+r.Path] = r.Engine
+	}
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			method := c.Request().Method
+			route := c.Path()
+			engine, ok := owners[method+" "+route]
+			if !ok {
+				// echo sets the request path for requests not matching a route, which would make the number of labels unbounded
+				route = ""
+			}
+			inFlight := httpRequestsInFlight.WithLabelValues(engine, method, route)
+			inFlight.Inc()
+			defer inFlight.Dec()
+
+			start := time.Now()
+			if err := next(c); err != nil {
+				c.Error(err)
+			}
+			status := fmt.Sprintf("%dxx", c.Response().Status/100)
+			httpRequests.WithLabelValues(engine, method, route, status).Inc()
+			httpRequestDuration.WithLabelValues(engine, method, route, status).Observe(time.Since(start).Seconds())
+			httpResponseSize.WithLabelValues(engine, method, route, status).Observe(float64(c.Response().Size))
+			return nil
+		}
+	}
+}
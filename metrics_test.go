@@ -20,6 +20,7 @@
 package core
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -28,6 +29,7 @@ import (
 	"testing"
 
 	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -61,3 +63,69 @@ func TestNewMetricsEngine(t *testing.T) {
 		assert.NoError(t, err)
 	})
 }
+
+func TestHTTPMetrics(t *testing.T) {
+	e := echo.New()
+	e.HTTPErrorHandler = ProblemErrorHandler
+	e.GET("/metrics-test/vendors/:id", func(c echo.Context) error {
+		return c.String(http.StatusOK, "vendor")
+	})
+	e.GET("/metrics-test/failing", func(c echo.Context) error {
+		return errors.New("failed")
+	})
+	e.GET("/metrics-test/panic", func(c echo.Context) error {
+		panic("boom")
+	})
+	e.Use(HTTPMetrics([]RouteInfo{
+		{Method: http.MethodGet, Path: "/metrics-test/vendors/:id", Engine: "Registry"},
+		{Method: http.MethodGet, Path: "/metrics-test/failing", Engine: "Registry"},
+		{Method: http.MethodGet, Path: "/metrics-test/panic", Engine: "Registry"},
+	}), Recover)
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	t.Run("requests are labelled with engine, route template and status class", func(t *testing.T) {
+		labels := []string{"Registry", http.MethodGet, "/metrics-test/vendors/:id", "2xx"}
+		before := testutil.ToFloat64(httpRequests.WithLabelValues(labels...))
+
+		serve("/metrics-test/vendors/1")
+		serve("/metrics-test/vendors/2")
+
+		assert.Equal(t, before+2, testutil.ToFloat64(httpRequests.WithLabelValues(labels...)))
+		assert.Equal(t, 0.0, testutil.ToFloat64(httpRequestsInFlight.WithLabelValues(labels[:3]...)))
+	})
+
+	t.Run("status of error responses is recorded", func(t *testing.T) {
+		requests := httpRequests.WithLabelValues("Registry", http.MethodGet, "/metrics-test/failing", "5xx")
+		before := testutil.ToFloat64(requests)
+
+		rec := serve("/metrics-test/failing")
+
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		assert.Equal(t, ProblemContentType, rec.Header().Get(echo.HeaderContentType))
+		assert.Equal(t, before+1, testutil.ToFloat64(requests))
+	})
+
+	t.Run("panics are recorded as server errors", func(t *testing.T) {
+		requests := httpRequests.WithLabelValues("Registry", http.MethodGet, "/metrics-test/panic", "5xx")
+		before := testutil.ToFloat64(requests)
+
+		serve("/metrics-test/panic")
+
+		assert.Equal(t, before+1, testutil.ToFloat64(requests))
+	})
+
+	t.Run("unknown routes are recorded without engine", func(t *testing.T) {
+		requests := httpRequests.WithLabelValues("", http.MethodGet, "", "4xx")
+		before := testutil.ToFloat64(requests)
+
+		rec := serve("/metrics-test/unknown")
+
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.Equal(t, before+1, testutil.ToFloat64(requests))
+	})
+}
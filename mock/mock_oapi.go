@@ -1,12 +1,12 @@
 // Code generated by MockGen. DO NOT EDIT.
-// Source: github.com/deepmap/oapi-codegen/pkg/runtime (interfaces: EchoRouter)
+// Source: github.com/nuts-foundation/nuts-go-core (interfaces: EchoRouter)
 
 // Package mock is a generated GoMock package.
 package mock
 
 import (
 	gomock "github.com/golang/mock/gomock"
-	v4 "github.com/labstack/echo/v4"
+	echo "github.com/labstack/echo/v4"
 	reflect "reflect"
 )
 
@@ -33,15 +33,34 @@ func (m *MockEchoRouter) EXPECT() *MockEchoRouterMockRecorder {
 	return m.recorder
 }
 
+// Any mocks base method
+func (m *MockEchoRouter) Any(arg0 string, arg1 echo.HandlerFunc, arg2 ...echo.MiddlewareFunc) []*echo.Route {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{arg0, arg1}
+	for _, a := range arg2 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Any", varargs...)
+	ret0, _ := ret[0].([]*echo.Route)
+	return ret0
+}
+
+// Any indicates an expected call of Any
+func (mr *MockEchoRouterMockRecorder) Any(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0, arg1}, arg2...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Any", reflect.TypeOf((*MockEchoRouter)(nil).Any), varargs...)
+}
+
 // CONNECT mocks base method
-func (m *MockEchoRouter) CONNECT(arg0 string, arg1 v4.HandlerFunc, arg2 ...v4.MiddlewareFunc) *v4.Route {
+func (m *MockEchoRouter) CONNECT(arg0 string, arg1 echo.HandlerFunc, arg2 ...echo.MiddlewareFunc) *echo.Route {
 	m.ctrl.T.Helper()
 	varargs := []interface{}{arg0, arg1}
 	for _, a := range arg2 {
 		varargs = append(varargs, a)
 	}
 	ret := m.ctrl.Call(m, "CONNECT", varargs...)
-	ret0, _ := ret[0].(*v4.Route)
+	ret0, _ := ret[0].(*echo.Route)
 	return ret0
 }
 
@@ -53,14 +72,14 @@ func (mr *MockEchoRouterMockRecorder) CONNECT(arg0, arg1 interface{}, arg2 ...in
 }
 
 // DELETE mocks base method
-func (m *MockEchoRouter) DELETE(arg0 string, arg1 v4.HandlerFunc, arg2 ...v4.MiddlewareFunc) *v4.Route {
+func (m *MockEchoRouter) DELETE(arg0 string, arg1 echo.HandlerFunc, arg2 ...echo.MiddlewareFunc) *echo.Route {
 	m.ctrl.T.Helper()
 	varargs := []interface{}{arg0, arg1}
 	for _, a := range arg2 {
 		varargs = append(varargs, a)
 	}
 	ret := m.ctrl.Call(m, "DELETE", varargs...)
-	ret0, _ := ret[0].(*v4.Route)
+	ret0, _ := ret[0].(*echo.Route)
 	return ret0
 }
 
@@ -72,14 +91,14 @@ func (mr *MockEchoRouterMockRecorder) DELETE(arg0, arg1 interface{}, arg2 ...int
 }
 
 // GET mocks base method
-func (m *MockEchoRouter) GET(arg0 string, arg1 v4.HandlerFunc, arg2 ...v4.MiddlewareFunc) *v4.Route {
+func (m *MockEchoRouter) GET(arg0 string, arg1 echo.HandlerFunc, arg2 ...echo.MiddlewareFunc) *echo.Route {
 	m.ctrl.T.Helper()
 	varargs := []interface{}{arg0, arg1}
 	for _, a := range arg2 {
 		varargs = append(varargs, a)
 	}
 	ret := m.ctrl.Call(m, "GET", varargs...)
-	ret0, _ := ret[0].(*v4.Route)
+	ret0, _ := ret[0].(*echo.Route)
 	return ret0
 }
 
@@ -90,15 +109,34 @@ func (mr *MockEchoRouterMockRecorder) GET(arg0, arg1 interface{}, arg2 ...interf
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GET", reflect.TypeOf((*MockEchoRouter)(nil).GET), varargs...)
 }
 
+// Group mocks base method
+func (m *MockEchoRouter) Group(arg0 string, arg1 ...echo.MiddlewareFunc) *echo.Group {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{arg0}
+	for _, a := range arg1 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Group", varargs...)
+	ret0, _ := ret[0].(*echo.Group)
+	return ret0
+}
+
+// Group indicates an expected call of Group
+func (mr *MockEchoRouterMockRecorder) Group(arg0 interface{}, arg1 ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0}, arg1...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Group", reflect.TypeOf((*MockEchoRouter)(nil).Group), varargs...)
+}
+
 // HEAD mocks base method
-func (m *MockEchoRouter) HEAD(arg0 string, arg1 v4.HandlerFunc, arg2 ...v4.MiddlewareFunc) *v4.Route {
+func (m *MockEchoRouter) HEAD(arg0 string, arg1 echo.HandlerFunc, arg2 ...echo.MiddlewareFunc) *echo.Route {
 	m.ctrl.T.Helper()
 	varargs := []interface{}{arg0, arg1}
 	for _, a := range arg2 {
 		varargs = append(varargs, a)
 	}
 	ret := m.ctrl.Call(m, "HEAD", varargs...)
-	ret0, _ := ret[0].(*v4.Route)
+	ret0, _ := ret[0].(*echo.Route)
 	return ret0
 }
 
@@ -110,14 +148,14 @@ func (mr *MockEchoRouterMockRecorder) HEAD(arg0, arg1 interface{}, arg2 ...inter
 }
 
 // OPTIONS mocks base method
-func (m *MockEchoRouter) OPTIONS(arg0 string, arg1 v4.HandlerFunc, arg2 ...v4.MiddlewareFunc) *v4.Route {
+func (m *MockEchoRouter) OPTIONS(arg0 string, arg1 echo.HandlerFunc, arg2 ...echo.MiddlewareFunc) *echo.Route {
 	m.ctrl.T.Helper()
 	varargs := []interface{}{arg0, arg1}
 	for _, a := range arg2 {
 		varargs = append(varargs, a)
 	}
 	ret := m.ctrl.Call(m, "OPTIONS", varargs...)
-	ret0, _ := ret[0].(*v4.Route)
+	ret0, _ := ret[0].(*echo.Route)
 	return ret0
 }
 
@@ -129,14 +167,14 @@ func (mr *MockEchoRouterMockRecorder) OPTIONS(arg0, arg1 interface{}, arg2 ...in
 }
 
 // PATCH mocks base method
-func (m *MockEchoRouter) PATCH(arg0 string, arg1 v4.HandlerFunc, arg2 ...v4.MiddlewareFunc) *v4.Route {
+func (m *MockEchoRouter) PATCH(arg0 string, arg1 echo.HandlerFunc, arg2 ...echo.MiddlewareFunc) *echo.Route {
 	m.ctrl.T.Helper()
 	varargs := []interface{}{arg0, arg1}
 	for _, a := range arg2 {
 		varargs = append(varargs, a)
 	}
 	ret := m.ctrl.Call(m, "PATCH", varargs...)
-	ret0, _ := ret[0].(*v4.Route)
+	ret0, _ := ret[0].(*echo.Route)
 	return ret0
 }
 
@@ -148,14 +186,14 @@ func (mr *MockEchoRouterMockRecorder) PATCH(arg0, arg1 interface{}, arg2 ...inte
 }
 
 // POST mocks base method
-func (m *MockEchoRouter) POST(arg0 string, arg1 v4.HandlerFunc, arg2 ...v4.MiddlewareFunc) *v4.Route {
+func (m *MockEchoRouter) POST(arg0 string, arg1 echo.HandlerFunc, arg2 ...echo.MiddlewareFunc) *echo.Route {
 	m.ctrl.T.Helper()
 	varargs := []interface{}{arg0, arg1}
 	for _, a := range arg2 {
 		varargs = append(varargs, a)
 	}
 	ret := m.ctrl.Call(m, "POST", varargs...)
-	ret0, _ := ret[0].(*v4.Route)
+	ret0, _ := ret[0].(*echo.Route)
 	return ret0
 }
 
@@ -167,14 +205,14 @@ func (mr *MockEchoRouterMockRecorder) POST(arg0, arg1 interface{}, arg2 ...inter
 }
 
 // PUT mocks base method
-func (m *MockEchoRouter) PUT(arg0 string, arg1 v4.HandlerFunc, arg2 ...v4.MiddlewareFunc) *v4.Route {
+func (m *MockEchoRouter) PUT(arg0 string, arg1 echo.HandlerFunc, arg2 ...echo.MiddlewareFunc) *echo.Route {
 	m.ctrl.T.Helper()
 	varargs := []interface{}{arg0, arg1}
 	for _, a := range arg2 {
 		varargs = append(varargs, a)
 	}
 	ret := m.ctrl.Call(m, "PUT", varargs...)
-	ret0, _ := ret[0].(*v4.Route)
+	ret0, _ := ret[0].(*echo.Route)
 	return ret0
 }
 
@@ -186,14 +224,14 @@ func (mr *MockEchoRouterMockRecorder) PUT(arg0, arg1 interface{}, arg2 ...interf
 }
 
 // TRACE mocks base method
-func (m *MockEchoRouter) TRACE(arg0 string, arg1 v4.HandlerFunc, arg2 ...v4.MiddlewareFunc) *v4.Route {
+func (m *MockEchoRouter) TRACE(arg0 string, arg1 echo.HandlerFunc, arg2 ...echo.MiddlewareFunc) *echo.Route {
 	m.ctrl.T.Helper()
 	varargs := []interface{}{arg0, arg1}
 	for _, a := range arg2 {
 		varargs = append(varargs, a)
 	}
 	ret := m.ctrl.Call(m, "TRACE", varargs...)
-	ret0, _ := ret[0].(*v4.Route)
+	ret0, _ := ret[0].(*echo.Route)
 	return ret0
 }
 
@@ -203,3 +241,19 @@ func (mr *MockEchoRouterMockRecorder) TRACE(arg0, arg1 interface{}, arg2 ...inte
 	varargs := append([]interface{}{arg0, arg1}, arg2...)
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TRACE", reflect.TypeOf((*MockEchoRouter)(nil).TRACE), varargs...)
 }
+
+// Use mocks base method
+func (m *MockEchoRouter) Use(arg0 ...echo.MiddlewareFunc) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{}
+	for _, a := range arg0 {
+		varargs = append(varargs, a)
+	}
+	m.ctrl.Call(m, "Use", varargs...)
+}
+
+// Use indicates an expected call of Use
+func (mr *MockEchoRouterMockRecorder) Use(arg0 ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Use", reflect.TypeOf((*MockEchoRouter)(nil).Use), arg0...)
+}
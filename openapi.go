@@ -0,0 +1,389 @@
+/*
+ * Nuts go core
+ * Copyright (C) 2020 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// OpenAPITitle is the title of the OpenAPI document of the node, which combines the documents of the engines
+const OpenAPITitle = "Nuts node API"
+
+// ErrInvalidOpenAPI is returned when the OpenAPI document of an engine can't be parsed
+var ErrInvalidOpenAPI = errors.New("invalid OpenAPI document")
+
+// ErrOpenAPICollision is returned when the OpenAPI documents of two engines define the same operation, or the same security scheme differently
+var ErrOpenAPICollision = errors.New("OpenAPI collision")
+
+// httpMethods are the keys of a path item holding an operation
+var httpMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+var nonIdentifierChars = regexp.MustCompile("[^A-Za-z0-9_.-]")
+
+// OpenAPIInfo describes the OpenAPI document of an engine
+type OpenAPIInfo struct {
+	// Engine is the name of the engine
+	Engine string `json:"engine"`
+	// Title is the title of the engine's document
+	Title string `json:"title"`
+	// Version is the version of the engine's API
+	Version string `json:"version"`
+	// Paths are the paths of the engine's document, including the engine's prefix when its routes are scoped
+	Paths []string `json:"paths"`
+}
+
+// engineOpenAPI is the parsed OpenAPI document of an engine
+type engineOpenAPI struct {
+	engine   *Engine
+	document map[string]interface{}
+}
+
+// EngineOpenAPI returns the OpenAPI document of the enabled engine with the given name, as provided by the engine except that
+// its paths are prefixed with /<ConfigKey> when its routes are scoped. It returns nil when the engine doesn't provide a document.
+func EngineOpenAPI(engines *EngineControl, name string) (map[string]interface{}, error) {
+	for _, e := range engines.Enabled() {
+		if e.Name == name {
+			doc, err := loadOpenAPI(e)
+			if doc == nil || err != nil {
+				return nil, err
+			}
+			return doc.document, nil
+		}
+	}
+	return nil, nil
+}
+
+// OpenAPIEngines describes the OpenAPI documents of the enabled engines providing one, in start order
+func OpenAPIEngines(engines *EngineControl) ([]OpenAPIInfo, error) {
+	docs, err := loadOpenAPIs(engines)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]OpenAPIInfo, 0, len(docs))
+	for _, doc := range docs {
+		info, _ := doc.document["info"].(map[string]interface{})
+		title, _ := info["title"].(string)
+		version, _ := info["version"].(string)
+		infos = append(infos, OpenAPIInfo{Engine: doc.engine.Name, Title: title, Version: version, Paths: sortedKeys(doc.document["paths"])})
+	}
+	return infos, nil
+}
+
+// OpenAPI returns the OpenAPI document of the node: the documents of the enabled engines combined into one.
+// Paths are prefixed with /<ConfigKey> for engines with scoped routes. Components and operation IDs with the same name but
+// a different definition in multiple documents are renamed to <engine>_<name>, references to them are updated.
+// The security requirements and servers of a document apply to its operations and path items that don't define their own.
+// It returns an error when two engines define the same operation, even identically, define a security scheme with the same name differently
+// or when a renamed component or operation ID already exists.
+func OpenAPI(engines *EngineControl) (map[string]interface{}, error) {
+	docs, err := loadOpenAPIs(engines)
+	if err != nil {
+		return nil, err
+	}
+	return mergeOpenAPI(docs)
+}
+
+func loadOpenAPIs(engines *EngineControl) ([]engineOpenAPI, error) {
+	ordered, err := engines.StartOrder()
+	if err != nil {
+		return nil, err
+	}
+	var docs []engineOpenAPI
+	for _, e := range ordered {
+		doc, err := loadOpenAPI(e)
+		if err != nil {
+			return nil, err
+		}
+		if doc != nil {
+			docs = append(docs, *doc)
+		}
+	}
+	return docs, nil
+}
+
+// loadOpenAPI parses the OpenAPI document of the engine and prefixes its paths, nil when the engine doesn't provide one
+func loadOpenAPI(e *Engine) (*engineOpenAPI, error) {
+	if e.OpenAPI == nil {
+		return nil, nil
+	}
+	data, err := e.OpenAPI()
+	if err != nil {
+		return nil, fmt.Errorf("unable to load OpenAPI document of engine %s: %w", e.Name, err)
+	}
+	document, err := parseOpenAPI(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w of engine %s: %v", ErrInvalidOpenAPI, e.Name, err)
+	}
+	if e.ScopedRoutes && e.ConfigKey != "" {
+		if paths, ok := document["paths"].(map[string]interface{}); ok {
+			prefixed := make(map[string]interface{}, len(paths))
+			for path, item := range paths {
+				prefixed["/"+e.ConfigKey+path] = item
+			}
+			document["paths"] = prefixed
+		}
+	}
+	return &engineOpenAPI{engine: e, document: document}, nil
+}
+
+// parseOpenAPI parses a JSON or YAML document
+func parseOpenAPI(data []byte) (map[string]interface{}, error) {
+	var document interface{}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		if err := json.Unmarshal(trimmed, &document); err != nil {
+			return nil, err
+		}
+	} else if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	result, ok := normalize(document).(map[string]interface{})
+	if !ok {
+		return nil, errors.New("document is not an object")
+	}
+	if _, ok := result["openapi"].(string); !ok {
+		return nil, errors.New("missing openapi version")
+	}
+	return result, nil
+}
+
+// normalize converts the maps produced by the YAML decoder to maps with string keys, as produced by the JSON decoder
+func normalize(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			result[fmt.Sprintf("%v", key)] = normalize(item)
+		}
+		return result
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = normalize(item)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalize(item)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func mergeOpenAPI(docs []engineOpenAPI) (map[string]interface{}, error) {
+	version := "3.0.0"
+	var versions []string
+	paths := map[string]interface{}{}
+	// owners holds the engine that defined a key of a path item, by path and key
+	owners := map[string]string{}
+	components := map[string]map[string]interface{}{}
+	operationIDs := map[string]bool{}
+	var tags []interface{}
+	tagNames := map[string]bool{}
+
+	for i, doc := range docs {
+		engine := doc.engine.Name
+		prefix := nonIdentifierChars.ReplaceAllString(engine, "_") + "_"
+		if i == 0 {
+			version = doc.document["openapi"].(string)
+		}
+		if info, ok := doc.document["info"].(map[string]interface{}); ok {
+			versions = append(versions, fmt.Sprintf("%s %v", engine, info["version"]))
+		}
+
+		// rename components clashing with a different component of a previous document
+		refs := map[string]string{}
+		docComponents, _ := doc.document["components"].(map[string]interface{})
+		for section, defs := range docComponents {
+			for name, def := range asMap(defs) {
+				if existing, ok := components[section][name]; ok && !reflect.DeepEqual(existing, def) {
+					if section == "securitySchemes" {
+						return nil, fmt.Errorf("%w: security scheme %s of engine %s differs from a previous engine", ErrOpenAPICollision, name, engine)
+					}
+					_, previous := components[section][prefix+name]
+					_, own := asMap(defs)[prefix+name]
+					if previous || own {
+						return nil, fmt.Errorf("%w: component %s of engine %s can't be renamed to %s, which already exists", ErrOpenAPICollision, name, engine, prefix+name)
+					}
+					refs["#/components/"+section+"/"+name] = "#/components/" + section + "/" + prefix + name
+				}
+			}
+		}
+		document := replaceRefs(doc.document, refs).(map[string]interface{})
+		inheritDocumentLevel(document)
+		docOperationIDs := operationIDsOf(document)
+
+		docComponents, _ = document["components"].(map[string]interface{})
+		for section, defs := range docComponents {
+			if components[section] == nil {
+				components[section] = map[string]interface{}{}
+			}
+			for name, def := range asMap(defs) {
+				if _, renamed := refs["#/components/"+section+"/"+name]; renamed {
+					name = prefix + name
+				}
+				components[section][name] = def
+			}
+		}
+
+		for path, item := range asMap(document["paths"]) {
+			merged := asMap(paths[path])
+			if merged == nil {
+				merged = map[string]interface{}{}
+				paths[path] = merged
+			}
+			for key, value := range asMap(item) {
+				// operations can only be defined by one engine, other keys (e.g. parameters) may be repeated identically
+				isOperation := contains(httpMethods, key)
+				if existing, ok := merged[key]; ok && (isOperation || !reflect.DeepEqual(existing, value)) {
+					return nil, fmt.Errorf("%w: %s %s defined by %s and %s", ErrOpenAPICollision, strings.ToUpper(key), path, owners[path+" "+key], engine)
+				}
+				if operation := asMap(value); operation != nil && isOperation {
+					if id, ok := operation["operationId"].(string); ok {
+						if operationIDs[id] {
+							if operationIDs[prefix+id] || docOperationIDs[prefix+id] {
+								return nil, fmt.Errorf("%w: operation ID %s of engine %s can't be renamed to %s, which already exists", ErrOpenAPICollision, id, engine, prefix+id)
+							}
+							operation["operationId"] = prefix + id
+						}
+						operationIDs[operation["operationId"].(string)] = true
+					}
+				}
+				merged[key] = value
+				owners[path+" "+key] = engine
+			}
+		}
+
+		tagList, _ := document["tags"].([]interface{})
+		for _, tag := range tagList {
+			if name, _ := asMap(tag)["name"].(string); !tagNames[name] {
+				tagNames[name] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+
+	result := map[string]interface{}{
+		"openapi": version,
+		"info": map[string]interface{}{
+			"title":   OpenAPITitle,
+			"version": strings.Join(versions, ", "),
+		},
+		"paths": paths,
+	}
+	if len(components) > 0 {
+		c := make(map[string]interface{}, len(components))
+		for section, defs := range components {
+			c[section] = defs
+		}
+		result["components"] = c
+	}
+	if len(tags) > 0 {
+		result["tags"] = tags
+	}
+	return result, nil
+}
+
+// inheritDocumentLevel copies the security requirements and servers of the document into the operations and path items that
+// don't define their own, since the combined document has none at document level
+func inheritDocumentLevel(document map[string]interface{}) {
+	security, hasSecurity := document["security"]
+	servers, hasServers := document["servers"]
+	for _, item := range asMap(document["paths"]) {
+		pathItem := asMap(item)
+		if pathItem == nil {
+			continue
+		}
+		if _, ok := pathItem["servers"]; hasServers && !ok {
+			pathItem["servers"] = servers
+		}
+		if !hasSecurity {
+			continue
+		}
+		for _, method := range httpMethods {
+			if operation := asMap(pathItem[method]); operation != nil {
+				if _, ok := operation["security"]; !ok {
+					operation["security"] = security
+				}
+			}
+		}
+	}
+}
+
+// operationIDsOf returns the operation IDs of the document
+func operationIDsOf(document map[string]interface{}) map[string]bool {
+	ids := map[string]bool{}
+	for _, item := range asMap(document["paths"]) {
+		for _, method := range httpMethods {
+			if id, ok := asMap(asMap(item)[method])["operationId"].(string); ok {
+				ids[id] = true
+			}
+		}
+	}
+	return ids
+}
+
+// replaceRefs returns a copy of the value with the $ref values replaced according to the given map
+func replaceRefs(value interface{}, refs map[string]string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			if ref, ok := item.(string); ok && key == "$ref" {
+				if replacement, ok := refs[ref]; ok {
+					item = replacement
+				}
+			}
+			result[key] = replaceRefs(item, refs)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = replaceRefs(item, refs)
+		}
+		return result
+	default:
+		return v
+	}
+}
+
+func asMap(value interface{}) map[string]interface{} {
+	m, _ := value.(map[string]interface{})
+	return m
+}
+
+func sortedKeys(value interface{}) []string {
+	keys := []string{}
+	for key := range asMap(value) {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
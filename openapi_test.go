@@ -0,0 +1,427 @@
+/*
+ * Nuts go core
+ * Copyright (C) 2020 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const registryOpenAPI = `
+openapi: 3.0.1
+info:
+  title: Registry API
+  version: 1.0.0
+tags:
+  - name: registry
+paths:
+  /organizations/{id}:
+    get:
+      operationId: getOrganization
+      tags: [registry]
+      responses:
+        "200":
+          content:
+            application/json:
+              schema:
+                $ref: '#/components/schemas/Organization'
+components:
+  schemas:
+    Organization:
+      type: object
+      properties:
+        name:
+          type: string
+    Identifier:
+      type: string
+  securitySchemes:
+    apiKey:
+      type: apiKey
+      in: header
+      name: X-API-Key
+`
+
+const cryptoOpenAPI = `{
+  "openapi": "3.0.0",
+  "info": {"title": "Crypto API", "version": "2.0.0"},
+  "tags": [{"name": "registry"}, {"name": "crypto"}],
+  "paths": {
+    "/organizations/{id}": {
+      "post": {
+        "operationId": "getOrganization",
+        "responses": {"200": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/Organization"}}}}}
+      }
+    }
+  },
+  "components": {
+    "schemas": {
+      "Organization": {"type": "string"},
+      "Identifier": {"type": "string"}
+    },
+    "securitySchemes": {
+      "apiKey": {"type": "apiKey", "in": "header", "name": "X-API-Key"}
+    }
+  }
+}`
+
+func openAPIEngine(name string, configKey string, document string) *Engine {
+	return &Engine{
+		Name:      name,
+		ConfigKey: configKey,
+		OpenAPI: func() ([]byte, error) {
+			return []byte(document), nil
+		},
+	}
+}
+
+// at returns the value at the given path of keys in a document
+func at(document map[string]interface{}, keys ...string) interface{} {
+	var value interface{} = document
+	for _, key := range keys {
+		value = asMap(value)[key]
+	}
+	return value
+}
+
+func TestEngineOpenAPI(t *testing.T) {
+	t.Run("YAML document is parsed", func(t *testing.T) {
+		ctl := NewEngineControl()
+		ctl.Register(openAPIEngine("Registry", "registry", registryOpenAPI))
+
+		doc, err := EngineOpenAPI(ctl, "Registry")
+
+		assert.NoError(t, err)
+		assert.Equal(t, "3.0.1", doc["openapi"])
+		assert.Equal(t, "getOrganization", at(doc, "paths", "/organizations/{id}", "get", "operationId"))
+	})
+
+	t.Run("paths of scoped routes are prefixed", func(t *testing.T) {
+		ctl := NewEngineControl()
+		e := openAPIEngine("Registry", "registry", registryOpenAPI)
+		e.ScopedRoutes = true
+		ctl.Register(e)
+
+		doc, err := EngineOpenAPI(ctl, "Registry")
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"/registry/organizations/{id}"}, sortedKeys(doc["paths"]))
+	})
+
+	t.Run("nil for engine without document", func(t *testing.T) {
+		ctl := NewEngineControl()
+		ctl.Register(&Engine{Name: "A"})
+
+		doc, err := EngineOpenAPI(ctl, "A")
+
+		assert.NoError(t, err)
+		assert.Nil(t, doc)
+	})
+
+	t.Run("nil for unknown engine", func(t *testing.T) {
+		doc, err := EngineOpenAPI(NewEngineControl(), "A")
+
+		assert.NoError(t, err)
+		assert.Nil(t, doc)
+	})
+
+	t.Run("error for invalid document", func(t *testing.T) {
+		ctl := NewEngineControl()
+		ctl.Register(openAPIEngine("A", "a", "info:\n  title: A"))
+
+		_, err := EngineOpenAPI(ctl, "A")
+
+		assert.True(t, errors.Is(err, ErrInvalidOpenAPI))
+		assert.EqualError(t, err, "invalid OpenAPI document of engine A: missing openapi version")
+	})
+
+	t.Run("error when loading fails", func(t *testing.T) {
+		ctl := NewEngineControl()
+		ctl.Register(&Engine{Name: "A", OpenAPI: func() ([]byte, error) {
+			return nil, errors.New("b00m!")
+		}})
+
+		_, err := EngineOpenAPI(ctl, "A")
+
+		assert.EqualError(t, err, "unable to load OpenAPI document of engine A: b00m!")
+	})
+}
+
+func TestOpenAPIEngines(t *testing.T) {
+	ctl := NewEngineControl()
+	ctl.Register(openAPIEngine("Registry", "registry", registryOpenAPI))
+	ctl.Register(&Engine{Name: "Other"})
+	crypto := openAPIEngine("Crypto", "crypto", cryptoOpenAPI)
+	crypto.ScopedRoutes = true
+	ctl.Register(crypto)
+
+	infos, err := OpenAPIEngines(ctl)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []OpenAPIInfo{
+		{Engine: "Registry", Title: "Registry API", Version: "1.0.0", Paths: []string{"/organizations/{id}"}},
+		{Engine: "Crypto", Title: "Crypto API", Version: "2.0.0", Paths: []string{"/crypto/organizations/{id}"}},
+	}, infos)
+}
+
+func TestOpenAPI(t *testing.T) {
+	t.Run("documents are merged", func(t *testing.T) {
+		ctl := NewEngineControl()
+		ctl.Register(openAPIEngine("Registry", "registry", registryOpenAPI))
+		ctl.Register(openAPIEngine("Crypto", "crypto", cryptoOpenAPI))
+
+		doc, err := OpenAPI(ctl)
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, "3.0.1", doc["openapi"])
+		assert.Equal(t, OpenAPITitle, at(doc, "info", "title"))
+		assert.Equal(t, "Registry 1.0.0, Crypto 2.0.0", at(doc, "info", "version"))
+		assert.Equal(t, []string{"get", "post"}, sortedKeys(at(doc, "paths", "/organizations/{id}")))
+		assert.Equal(t, []interface{}{
+			map[string]interface{}{"name": "registry"},
+			map[string]interface{}{"name": "crypto"},
+		}, doc["tags"])
+	})
+
+	t.Run("clashing components are renamed", func(t *testing.T) {
+		ctl := NewEngineControl()
+		ctl.Register(openAPIEngine("Registry", "registry", registryOpenAPI))
+		ctl.Register(openAPIEngine("Crypto", "crypto", cryptoOpenAPI))
+
+		doc, _ := OpenAPI(ctl)
+
+		assert.Equal(t, []string{"Crypto_Organization", "Identifier", "Organization"}, sortedKeys(at(doc, "components", "schemas")))
+		assert.Equal(t, "object", at(doc, "components", "schemas", "Organization", "type"))
+		assert.Equal(t, "string", at(doc, "components", "schemas", "Crypto_Organization", "type"))
+		response := []string{"responses", "200", "content", "application/json", "schema", "$ref"}
+		assert.Equal(t, "#/components/schemas/Organization", at(doc, append([]string{"paths", "/organizations/{id}", "get"}, response...)...))
+		assert.Equal(t, "#/components/schemas/Crypto_Organization", at(doc, append([]string{"paths", "/organizations/{id}", "post"}, response...)...))
+		assert.Equal(t, []string{"apiKey"}, sortedKeys(at(doc, "components", "securitySchemes")))
+	})
+
+	t.Run("clashing operation IDs are prefixed", func(t *testing.T) {
+		ctl := NewEngineControl()
+		ctl.Register(openAPIEngine("Registry", "registry", registryOpenAPI))
+		ctl.Register(openAPIEngine("Crypto", "crypto", cryptoOpenAPI))
+
+		doc, _ := OpenAPI(ctl)
+
+		assert.Equal(t, "getOrganization", at(doc, "paths", "/organizations/{id}", "get", "operationId"))
+		assert.Equal(t, "Crypto_getOrganization", at(doc, "paths", "/organizations/{id}", "post", "operationId"))
+	})
+
+	t.Run("document security and servers apply to its operations", func(t *testing.T) {
+		ctl := NewEngineControl()
+		ctl.Register(openAPIEngine("A", "a", `{"openapi": "3.0.0", "security": [{"apiKey": []}], "servers": [{"url": "/api"}],
+			"paths": {"/a": {"get": {}, "post": {"security": []}}, "/b": {"servers": [{"url": "/other"}], "get": {}}}}`))
+		ctl.Register(openAPIEngine("B", "b", `{"openapi": "3.0.0", "paths": {"/c": {"get": {}}}}`))
+
+		doc, err := OpenAPI(ctl)
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		requirements := []interface{}{map[string]interface{}{"apiKey": []interface{}{}}}
+		assert.Equal(t, requirements, at(doc, "paths", "/a", "get", "security"))
+		assert.Equal(t, []interface{}{}, at(doc, "paths", "/a", "post", "security"))
+		assert.Equal(t, requirements, at(doc, "paths", "/b", "get", "security"))
+		assert.Nil(t, at(doc, "paths", "/c", "get", "security"))
+		assert.Equal(t, []interface{}{map[string]interface{}{"url": "/api"}}, at(doc, "paths", "/a", "servers"))
+		assert.Equal(t, []interface{}{map[string]interface{}{"url": "/other"}}, at(doc, "paths", "/b", "servers"))
+		assert.Nil(t, at(doc, "paths", "/c", "servers"))
+	})
+
+	t.Run("error when a renamed component already exists", func(t *testing.T) {
+		ctl := NewEngineControl()
+		ctl.Register(openAPIEngine("Registry", "registry", registryOpenAPI))
+		ctl.Register(openAPIEngine("Crypto", "crypto", `{"openapi": "3.0.0", "components": {"schemas": {
+			"Organization": {"type": "string"}, "Crypto_Organization": {"type": "integer"}}}}`))
+
+		_, err := OpenAPI(ctl)
+
+		assert.True(t, errors.Is(err, ErrOpenAPICollision))
+		assert.EqualError(t, err, "OpenAPI collision: component Organization of engine Crypto can't be renamed to Crypto_Organization, which already exists")
+	})
+
+	t.Run("error when a renamed operation ID already exists", func(t *testing.T) {
+		ctl := NewEngineControl()
+		ctl.Register(openAPIEngine("Registry", "registry", registryOpenAPI))
+		ctl.Register(openAPIEngine("Other", "other", `{"openapi": "3.0.0", "paths": {"/a": {"get": {"operationId": "getOrganization"}},
+			"/b": {"get": {"operationId": "Other_getOrganization"}}}}`))
+
+		_, err := OpenAPI(ctl)
+
+		assert.True(t, errors.Is(err, ErrOpenAPICollision))
+		assert.EqualError(t, err, "OpenAPI collision: operation ID getOrganization of engine Other can't be renamed to Other_getOrganization, which already exists")
+	})
+
+	t.Run("engines without document are skipped", func(t *testing.T) {
+		ctl := NewEngineControl()
+		ctl.Register(&Engine{Name: "A"})
+
+		doc, err := OpenAPI(ctl)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "3.0.0", doc["openapi"])
+		assert.Empty(t, doc["paths"])
+	})
+
+	t.Run("error on operation collision", func(t *testing.T) {
+		ctl := NewEngineControl()
+		ctl.Register(openAPIEngine("Registry", "registry", registryOpenAPI))
+		ctl.Register(openAPIEngine("Other", "other", `{"openapi": "3.0.0", "paths": {"/organizations/{id}": {"get": {}}}}`))
+
+		_, err := OpenAPI(ctl)
+
+		assert.True(t, errors.Is(err, ErrOpenAPICollision))
+		assert.EqualError(t, err, "OpenAPI collision: GET /organizations/{id} defined by Registry and Other")
+	})
+
+	t.Run("error on identical operation defined by two engines", func(t *testing.T) {
+		operation := `{"openapi": "3.0.0", "paths": {"/shared": {"parameters": [{"name": "id", "in": "query"}], "get": {"responses": {}}}}}`
+		ctl := NewEngineControl()
+		ctl.Register(openAPIEngine("A", "a", operation))
+		ctl.Register(openAPIEngine("B", "b", operation))
+
+		_, err := OpenAPI(ctl)
+
+		assert.True(t, errors.Is(err, ErrOpenAPICollision))
+		assert.EqualError(t, err, "OpenAPI collision: GET /shared defined by A and B")
+	})
+
+	t.Run("identical path item fields of different operations are merged", func(t *testing.T) {
+		ctl := NewEngineControl()
+		ctl.Register(openAPIEngine("A", "a", `{"openapi": "3.0.0", "paths": {"/shared": {"summary": "shared", "get": {}}}}`))
+		ctl.Register(openAPIEngine("B", "b", `{"openapi": "3.0.0", "paths": {"/shared": {"summary": "shared", "post": {}}}}`))
+
+		doc, err := OpenAPI(ctl)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "shared", at(doc, "paths", "/shared", "summary"))
+		assert.NotNil(t, at(doc, "paths", "/shared", "get"))
+		assert.NotNil(t, at(doc, "paths", "/shared", "post"))
+	})
+
+	t.Run("error on security scheme collision", func(t *testing.T) {
+		ctl := NewEngineControl()
+		ctl.Register(openAPIEngine("Registry", "registry", registryOpenAPI))
+		ctl.Register(openAPIEngine("Other", "other", `{"openapi": "3.0.0", "components": {"securitySchemes": {"apiKey": {"type": "http"}}}}`))
+
+		_, err := OpenAPI(ctl)
+
+		assert.True(t, errors.Is(err, ErrOpenAPICollision))
+		assert.EqualError(t, err, "OpenAPI collision: security scheme apiKey of engine Other differs from a previous engine")
+	})
+}
+
+func TestNewStatusEngine_OpenAPI(t *testing.T) {
+	ctl := NewEngineControl()
+	ctl.Register(NewStatusEngineFor(ctl))
+	ctl.Register(openAPIEngine("Registry", "registry", registryOpenAPI))
+	ctl.Register(&Engine{Name: "Other"})
+	server, err := NewHTTPServer(ctl, NewNutsGlobalConfig())
+	if !assert.NoError(t, err) {
+		return
+	}
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		server.Echo().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	t.Run("combined document", func(t *testing.T) {
+		rec := get("/status/openapi")
+
+		var doc map[string]interface{}
+		_ = json.Unmarshal(rec.Body.Bytes(), &doc)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, OpenAPITitle, at(doc, "info", "title"))
+	})
+
+	t.Run("engines", func(t *testing.T) {
+		rec := get("/status/openapi/engines")
+
+		var infos []OpenAPIInfo
+		_ = json.Unmarshal(rec.Body.Bytes(), &infos)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Len(t, infos, 1)
+	})
+
+	t.Run("document of engine", func(t *testing.T) {
+		rec := get("/status/openapi/engines/Registry")
+
+		var doc map[string]interface{}
+		_ = json.Unmarshal(rec.Body.Bytes(), &doc)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "Registry API", at(doc, "info", "title"))
+	})
+
+	t.Run("404 for engine without document", func(t *testing.T) {
+		rec := get("/status/openapi/engines/Other")
+
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
+
+func TestNewStatusEngine_OpenAPICmd(t *testing.T) {
+	execute := func(args ...string) (string, error) {
+		ctl := NewEngineControl()
+		ctl.Register(openAPIEngine("Registry", "registry", registryOpenAPI))
+		ctl.Register(&Engine{Name: "Other"})
+		cmd := NewStatusEngineFor(ctl).Cmd
+		buf := new(bytes.Buffer)
+		cmd.SetOut(buf)
+		cmd.SetErr(buf)
+		cmd.SetArgs(append([]string{"openapi"}, args...))
+		err := cmd.Execute()
+		return buf.String(), err
+	}
+
+	t.Run("combined document", func(t *testing.T) {
+		out, err := execute()
+
+		var doc map[string]interface{}
+		assert.NoError(t, err)
+		assert.NoError(t, json.Unmarshal([]byte(out), &doc))
+		assert.Equal(t, OpenAPITitle, at(doc, "info", "title"))
+	})
+
+	t.Run("document of engine", func(t *testing.T) {
+		out, err := execute("--engine", "Registry")
+
+		var doc map[string]interface{}
+		assert.NoError(t, err)
+		assert.NoError(t, json.Unmarshal([]byte(out), &doc))
+		assert.Equal(t, "Registry API", at(doc, "info", "title"))
+	})
+
+	t.Run("error for engine without document", func(t *testing.T) {
+		_, err := execute("--engine", "Other")
+
+		assert.EqualError(t, err, "engine Other has no OpenAPI document")
+	})
+}
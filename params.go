@@ -0,0 +1,95 @@
+/*
+ * Nuts go core
+ * Copyright (C) 2020 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package core
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	pathParam   = "path"
+	queryParam  = "query"
+	headerParam = "header"
+)
+
+// PathPartyID returns the PartyID in the path parameter with the given name, e.g. "id" for route /vendors/:id.
+// It returns a 400 Bad Request ProblemError when the parameter is missing or isn't a valid PartyID.
+func PathPartyID(c echo.Context, name string) (PartyID, error) {
+	return bindPartyID(pathParam, name, c.Param(name))
+}
+
+// QueryPartyID returns the PartyID in the query parameter with the given name.
+// It returns a 400 Bad Request ProblemError when the parameter is missing or isn't a valid PartyID.
+func QueryPartyID(c echo.Context, name string) (PartyID, error) {
+	return bindPartyID(queryParam, name, c.QueryParam(name))
+}
+
+// HeaderPartyID returns the PartyID in the request header with the given name.
+// It returns a 400 Bad Request ProblemError when the header is missing or isn't a valid PartyID.
+func HeaderPartyID(c echo.Context, name string) (PartyID, error) {
+	return bindPartyID(headerParam, name, c.Request().Header.Get(name))
+}
+
+func bindPartyID(in string, name string, value string) (PartyID, error) {
+	if value == "" {
+		return PartyID{}, invalidPartyID(fmt.Errorf("%w: missing %s parameter %s", ErrInvalidPartyID, in, name), in, name)
+	}
+	partyID, err := decodePartyID(value)
+	if err != nil {
+		return PartyID{}, invalidPartyID(fmt.Errorf("%w in %s parameter %s: %s", ErrInvalidPartyID, in, name, value), in, name)
+	}
+	return partyID, nil
+}
+
+// invalidPartyID returns the ProblemError for an invalid PartyID parameter, with the location and name of the parameter
+// as extension members when known
+func invalidPartyID(err error, in string, name string) *ProblemError {
+	problem := NewProblemError(err, http.StatusBadRequest, "")
+	if name != "" {
+		problem.Extensions = map[string]interface{}{"in": in, "parameter": name}
+	}
+	return problem
+}
+
+// DecodeURIPath is a echo middleware that decodes path parameters. echo matches routes on the escaped path when the
+// request path contains escaped characters and doesn't decode the parameters in that case (https://github.com/labstack/echo/issues/1258).
+// Parameters are only decoded in that case, so a parameter is never decoded twice.
+func DecodeURIPath(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if c.Request().URL.RawPath == "" {
+			return next(c)
+		}
+		values := make([]string, len(c.ParamValues()))
+		for i, value := range c.ParamValues() {
+			decoded, err := url.PathUnescape(value)
+			if err != nil {
+				decoded = value
+			}
+			values[i] = decoded
+		}
+		c.SetParamNames(c.ParamNames()...)
+		c.SetParamValues(values...)
+		return next(c)
+	}
+}
@@ -0,0 +1,221 @@
+/*
+ * Nuts go core
+ * Copyright (C) 2020 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+const testPartyID = "urn:oid:2.16.840.1.113883.2.4.6.1:87654321"
+
+// servePartyID serves the request on a server with the route /vendors/:id, which responds with the PartyID returned by bind
+func servePartyID(t *testing.T, req *http.Request, bind func(c echo.Context) (PartyID, error)) *httptest.ResponseRecorder {
+	server := newTestServer(t, NewNutsGlobalConfig(), &Engine{Name: "a", Routes: func(router EchoRouter) {
+		router.GET("/vendors/:id", func(c echo.Context) error {
+			id, err := bind(c)
+			if err != nil {
+				return err
+			}
+			return c.String(http.StatusOK, id.String())
+		})
+	}})
+	rec := httptest.NewRecorder()
+	server.Echo().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestPathPartyID(t *testing.T) {
+	bind := func(c echo.Context) (PartyID, error) {
+		return PathPartyID(c, "id")
+	}
+
+	t.Run("URL-encoded", func(t *testing.T) {
+		rec := servePartyID(t, httptest.NewRequest(http.MethodGet, "/vendors/urn%3Aoid%3A2.16.840.1.113883.2.4.6.1%3A87654321", nil), bind)
+
+		assert.Equal(t, testPartyID, rec.Body.String())
+	})
+
+	t.Run("not encoded", func(t *testing.T) {
+		rec := servePartyID(t, httptest.NewRequest(http.MethodGet, "/vendors/"+testPartyID, nil), bind)
+
+		assert.Equal(t, testPartyID, rec.Body.String())
+	})
+
+	t.Run("without DecodeURIPath", func(t *testing.T) {
+		e := echo.New()
+		c := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+		c.SetParamNames("id")
+		c.SetParamValues("urn%3Aoid%3A2.16.840.1.113883.2.4.6.1%3A87654321")
+
+		id, err := PathPartyID(c, "id")
+
+		assert.NoError(t, err)
+		assert.Equal(t, testPartyID, id.String())
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		rec := servePartyID(t, httptest.NewRequest(http.MethodGet, "/vendors/foo", nil), bind)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, map[string]interface{}{
+			"type":      "about:blank",
+			"title":     "Bad Request",
+			"status":    float64(400),
+			"detail":    "invalid PartyID in path parameter id: foo",
+			"in":        "path",
+			"parameter": "id",
+			"requestId": rec.Header().Get(echo.HeaderXRequestID),
+		}, problemBody(t, rec))
+	})
+}
+
+func TestQueryPartyID(t *testing.T) {
+	bind := func(c echo.Context) (PartyID, error) {
+		return QueryPartyID(c, "owner")
+	}
+
+	t.Run("URL-encoded", func(t *testing.T) {
+		rec := servePartyID(t, httptest.NewRequest(http.MethodGet, "/vendors/1?owner=urn%3Aoid%3A2.16.840.1.113883.2.4.6.1%3A87654321", nil), bind)
+
+		assert.Equal(t, testPartyID, rec.Body.String())
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		rec := servePartyID(t, httptest.NewRequest(http.MethodGet, "/vendors/1", nil), bind)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, "invalid PartyID: missing query parameter owner", problemBody(t, rec)["detail"])
+	})
+}
+
+func TestHeaderPartyID(t *testing.T) {
+	bind := func(c echo.Context) (PartyID, error) {
+		return HeaderPartyID(c, "X-Party")
+	}
+
+	for _, value := range []string{testPartyID, "urn%3Aoid%3A2.16.840.1.113883.2.4.6.1%3A87654321"} {
+		req := httptest.NewRequest(http.MethodGet, "/vendors/1", nil)
+		req.Header.Set("X-Party", value)
+
+		rec := servePartyID(t, req, bind)
+
+		assert.Equal(t, testPartyID, rec.Body.String())
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/vendors/1", nil)
+		req.Header.Set("X-Party", "urn:oid:1.2.3:")
+
+		_, err := HeaderPartyID(echo.New().NewContext(req, httptest.NewRecorder()), "X-Party")
+
+		assert.True(t, errors.Is(err, ErrInvalidPartyID))
+	})
+}
+
+func TestPartyID_Bind(t *testing.T) {
+	type request struct {
+		ID    PartyID `param:"id"`
+		Owner PartyID `query:"owner"`
+	}
+	bind := func(c echo.Context) (PartyID, error) {
+		var r request
+		if err := c.Bind(&r); err != nil {
+			return PartyID{}, err
+		}
+		return r.Owner, nil
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		rec := servePartyID(t, httptest.NewRequest(http.MethodGet, "/vendors/"+testPartyID+"?owner=urn%3Aoid%3A1.2%3Afoo", nil), bind)
+
+		assert.Equal(t, "urn:oid:1.2:foo", rec.Body.String())
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		rec := servePartyID(t, httptest.NewRequest(http.MethodGet, "/vendors/"+testPartyID+"?owner=foo", nil), bind)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, "invalid PartyID: foo", problemBody(t, rec)["detail"])
+	})
+}
+
+func TestDecodeURIPath(t *testing.T) {
+	rawParam := "urn:oid:2.16.840.1.113883.2.4.6.1:87654321"
+	encodedParam := "urn%3Aoid%3A2.16.840.1.113883.2.4.6.1%3A87654321"
+
+	t.Run("without middleware, it returns the encoded param", func(t *testing.T) {
+		e := echo.New()
+		r := e.Router()
+		r.Add(http.MethodGet, "/api/:someparam", func(context echo.Context) error {
+			param := context.Param("someparam")
+			return context.Blob(200, "text/plain", []byte(param))
+		})
+
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/%v", encodedParam), nil)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		defer rec.Result().Body.Close()
+		bodyBytes, _ := ioutil.ReadAll(rec.Result().Body)
+		assert.Equal(t, encodedParam, string(bodyBytes))
+	})
+
+	t.Run("with middleware, it return the decoded param", func(t *testing.T) {
+		e := echo.New()
+		r := e.Router()
+		e.Use(DecodeURIPath)
+		r.Add(http.MethodGet, "/api/:someparam", func(context echo.Context) error {
+			param := context.Param("someparam")
+			return context.Blob(200, "text/plain", []byte(param))
+		})
+
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/%v", encodedParam), nil)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		defer rec.Result().Body.Close()
+		bodyBytes, _ := ioutil.ReadAll(rec.Result().Body)
+		assert.Equal(t, rawParam, string(bodyBytes))
+	})
+
+	t.Run("with middleware, it doesn't decode params twice", func(t *testing.T) {
+		e := echo.New()
+		e.Use(DecodeURIPath)
+		e.GET("/api/:someparam", func(context echo.Context) error {
+			return context.String(http.StatusOK, context.Param("someparam"))
+		})
+
+		for path, expected := range map[string]string{
+			"/api/a%2541":      "a%41",
+			"/api/a%41%3Ab":    "aA:b",
+			"/api/" + rawParam: rawParam,
+		} {
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+			assert.Equal(t, expected, rec.Body.String(), path)
+		}
+	})
+}
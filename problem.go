@@ -0,0 +1,218 @@
+/*
+ * Nuts go core
+ * Copyright (C) 2020 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+// ProblemContentType is the content type of RFC 7807 problem details responses
+const ProblemContentType = "application/problem+json"
+
+// defaultProblemType is the problem type of problems without a specific type, see RFC 7807 section 4.2
+const defaultProblemType = "about:blank"
+
+// DefaultRetryAfter is the Retry-After of responses to recoverable errors that don't specify one
+const DefaultRetryAfter = 30 * time.Second
+
+// Problem holds the RFC 7807 problem details of an error response
+type Problem struct {
+	// Type is a URI identifying the problem type
+	Type string `json:"type"`
+	// Title is a short, human readable summary of the problem type
+	Title string `json:"title"`
+	// Status is the HTTP status code
+	Status int `json:"status"`
+	// Detail is a human readable explanation specific to this occurrence of the problem
+	Detail string `json:"detail,omitempty"`
+	// Extensions holds additional members of the problem details object
+	Extensions map[string]interface{} `json:"-"`
+}
+
+// MarshalJSON adds the extension members to the problem details object
+func (p Problem) MarshalJSON() ([]byte, error) {
+	members := map[string]interface{}{}
+	for k, v := range p.Extensions {
+		members[k] = v
+	}
+	members["type"] = p.Type
+	members["title"] = p.Title
+	members["status"] = p.Status
+	if p.Detail != "" {
+		members["detail"] = p.Detail
+	}
+	return json.Marshal(members)
+}
+
+// ProblemError is an error which is rendered with the given problem details by ProblemErrorHandler
+type ProblemError struct {
+	// Err is the underlying error
+	Err error
+	// Type is a URI identifying the problem type, about:blank when empty
+	Type string
+	// Status is the HTTP status code, when 0 it's derived from Err
+	Status int
+	// Detail is a human readable explanation of the problem, when empty the message of Err is used for 4xx statuses
+	Detail string
+	// Extensions holds additional members of the problem details object
+	Extensions map[string]interface{}
+	// RetryAfter is the value of the Retry-After header of 503 and 429 responses, DefaultRetryAfter when 0 and Err is recoverable
+	RetryAfter time.Duration
+}
+
+// StatusError is an error that is rendered with its own HTTP status code by ProblemErrorHandler, see NewStatusError
+type StatusError interface {
+	error
+
+	// HTTPStatus returns the HTTP status code of responses to the error
+	HTTPStatus() int
+}
+
+type statusError struct {
+	msg    string
+	status int
+}
+
+// NewStatusError creates an error that is rendered with the given HTTP status code, also when it's wrapped.
+// It's meant for sentinel errors of an engine, e.g.:
+//  var ErrVendorNotFound = core.NewStatusError("vendor not found", http.StatusNotFound)
+func NewStatusError(msg string, status int) StatusError {
+	return &statusError{msg: msg, status: status}
+}
+
+func (s *statusError) Error() string {
+	return s.msg
+}
+
+// HTTPStatus returns the HTTP status code of responses to the error
+func (s *statusError) HTTPStatus() int {
+	return s.status
+}
+
+// NewProblemError creates a ProblemError with the given status and problem type URI
+func NewProblemError(err error, status int, problemType string) *ProblemError {
+	return &ProblemError{Err: err, Status: status, Type: problemType}
+}
+
+func (p *ProblemError) Error() string {
+	return p.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (p *ProblemError) Unwrap() error {
+	return p.Err
+}
+
+// ProblemErrorHandler is an echo HTTP error handler that renders errors as RFC 7807 problem details:
+//  * echo.HTTPError: the status code of the error, with its message as detail
+//  * StatusError: the status code of the error, with the message as detail for 4xx statuses
+//  * ProblemError: the given problem details, its status is derived from the underlying error when not set
+//  * recoverable Error: 503 Service Unavailable with a Retry-After header
+//  * other errors, including non-recoverable Errors: 500 Internal Server Error, the message is logged but not returned
+// The messages of errors resulting in a 5xx status are only returned when they're recoverable or set explicitly as Detail.
+// The request ID set by the RequestID middleware is added to the problem details as requestId member.
+func ProblemErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	problem, retryAfter := problemFor(err)
+	if id := RequestIDFrom(c.Request().Context()); id != "" {
+		extensions := map[string]interface{}{requestIDField: id}
+		for k, v := range problem.Extensions {
+			extensions[k] = v
+		}
+		problem.Extensions = extensions
+	}
+	if problem.Status >= http.StatusInternalServerError {
+		Log(c.Request().Context()).Errorf("Error handling %s %s: %v", c.Request().Method, c.Request().URL.Path, err)
+	}
+	if retryAfter > 0 {
+		c.Response().Header().Set("Retry-After", strconv.Itoa(int((retryAfter+time.Second-1)/time.Second)))
+	}
+
+	var writeErr error
+	if c.Request().Method == http.MethodHead {
+		writeErr = c.NoContent(problem.Status)
+	} else {
+		data, _ := json.Marshal(problem)
+		writeErr = c.Blob(problem.Status, ProblemContentType, data)
+	}
+	if writeErr != nil {
+		log.Errorf("Unable to write error response: %v", writeErr)
+	}
+}
+
+// problemFor returns the problem details for the error and the Retry-After for 503 and 429 responses (0 otherwise)
+func problemFor(err error) (Problem, time.Duration) {
+	problem := Problem{Type: defaultProblemType, Status: http.StatusInternalServerError}
+	var retryAfter time.Duration
+
+	var httpErr *echo.HTTPError
+	var statusErr StatusError
+	var coreErr Error
+	switch {
+	case errors.As(err, &httpErr):
+		problem.Status = httpErr.Code
+		problem.Detail = fmt.Sprintf("%v", httpErr.Message)
+	case errors.As(err, &statusErr):
+		problem.Status = statusErr.HTTPStatus()
+		if problem.Status < http.StatusInternalServerError {
+			problem.Detail = err.Error()
+		}
+	case errors.As(err, &coreErr) && coreErr.Recoverable():
+		problem.Status = http.StatusServiceUnavailable
+		problem.Detail = coreErr.Error()
+		retryAfter = DefaultRetryAfter
+	}
+
+	var problemErr *ProblemError
+	if errors.As(err, &problemErr) {
+		if problemErr.Type != "" {
+			problem.Type = problemErr.Type
+		}
+		if problemErr.Status != 0 {
+			problem.Status = problemErr.Status
+		}
+		switch {
+		case problemErr.Detail != "":
+			problem.Detail = problemErr.Detail
+		case problem.Status < http.StatusInternalServerError:
+			problem.Detail = problemErr.Err.Error()
+		}
+		problem.Extensions = problemErr.Extensions
+		if problemErr.RetryAfter > 0 {
+			retryAfter = problemErr.RetryAfter
+		}
+	}
+	if problem.Status != http.StatusServiceUnavailable && problem.Status != http.StatusTooManyRequests {
+		retryAfter = 0
+	}
+	problem.Title = http.StatusText(problem.Status)
+	return problem, retryAfter
+}
@@ -0,0 +1,189 @@
+/*
+ * Nuts go core
+ * Copyright (C) 2020 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// serveError returns the response of a server whose handler returns the given error
+func serveError(method string, err error) *httptest.ResponseRecorder {
+	e := echo.New()
+	e.HTTPErrorHandler = ProblemErrorHandler
+	e.Any("/", func(c echo.Context) error {
+		return err
+	})
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(method, "/", nil))
+	return rec
+}
+
+func problemBody(t *testing.T, rec *httptest.ResponseRecorder) map[string]interface{} {
+	assert.Equal(t, ProblemContentType, rec.Header().Get(echo.HeaderContentType))
+	var body map[string]interface{}
+	if !assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body)) {
+		t.FailNow()
+	}
+	return body
+}
+
+func TestProblemErrorHandler(t *testing.T) {
+	t.Run("echo HTTPError", func(t *testing.T) {
+		rec := serveError(http.MethodGet, echo.NewHTTPError(http.StatusBadRequest, "invalid id"))
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, map[string]interface{}{
+			"type":   "about:blank",
+			"title":  "Bad Request",
+			"status": float64(400),
+			"detail": "invalid id",
+		}, problemBody(t, rec))
+	})
+
+	t.Run("recoverable error", func(t *testing.T) {
+		rec := serveError(http.MethodGet, NewError("database unavailable", true))
+
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Equal(t, "30", rec.Header().Get("Retry-After"))
+		body := problemBody(t, rec)
+		assert.Equal(t, "Service Unavailable", body["title"])
+		assert.Equal(t, "database unavailable", body["detail"])
+	})
+
+	t.Run("wrapped recoverable error", func(t *testing.T) {
+		rec := serveError(http.MethodGet, fmt.Errorf("unable to list: %w", NewError("timeout", true)))
+
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	})
+
+	t.Run("non-recoverable error", func(t *testing.T) {
+		rec := serveError(http.MethodGet, NewError("invalid state", false))
+
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		assert.Empty(t, rec.Header().Get("Retry-After"))
+		assert.NotContains(t, problemBody(t, rec), "detail")
+	})
+
+	t.Run("status error", func(t *testing.T) {
+		errNotFound := NewStatusError("vendor not found", http.StatusNotFound)
+
+		rec := serveError(http.MethodGet, fmt.Errorf("unable to get vendor 4: %w", errNotFound))
+
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		body := problemBody(t, rec)
+		assert.Equal(t, "Not Found", body["title"])
+		assert.Equal(t, "unable to get vendor 4: vendor not found", body["detail"])
+	})
+
+	t.Run("status error with server error status doesn't leak its message", func(t *testing.T) {
+		rec := serveError(http.MethodGet, NewStatusError("secret", http.StatusBadGateway))
+
+		assert.Equal(t, http.StatusBadGateway, rec.Code)
+		assert.NotContains(t, problemBody(t, rec), "detail")
+	})
+
+	t.Run("problem error derives status from wrapped status error", func(t *testing.T) {
+		err := NewProblemError(NewStatusError("vendor exists", http.StatusConflict), 0, "https://nuts.nl/problems/vendor-exists")
+
+		rec := serveError(http.MethodPost, err)
+
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		assert.Equal(t, "vendor exists", problemBody(t, rec)["detail"])
+	})
+
+	t.Run("problem error with server error status doesn't leak its message", func(t *testing.T) {
+		rec := serveError(http.MethodGet, NewProblemError(errors.New("secret"), http.StatusInternalServerError, ""))
+
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		assert.NotContains(t, problemBody(t, rec), "detail")
+	})
+
+	t.Run("plain errors don't leak their message", func(t *testing.T) {
+		rec := serveError(http.MethodGet, errors.New("secret"))
+
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		body := problemBody(t, rec)
+		assert.Equal(t, "Internal Server Error", body["title"])
+		assert.NotContains(t, body, "detail")
+	})
+
+	t.Run("problem error with type, detail and extensions", func(t *testing.T) {
+		err := NewProblemError(errors.New("vendor exists"), http.StatusConflict, "https://nuts.nl/problems/vendor-exists")
+		err.Detail = "vendor urn:oid:1.2.3:4 already exists"
+		err.Extensions = map[string]interface{}{"vendor": "urn:oid:1.2.3:4", "status": "ignored"}
+
+		rec := serveError(http.MethodPost, err)
+
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		assert.Equal(t, map[string]interface{}{
+			"type":   "https://nuts.nl/problems/vendor-exists",
+			"title":  "Conflict",
+			"status": float64(409),
+			"detail": "vendor urn:oid:1.2.3:4 already exists",
+			"vendor": "urn:oid:1.2.3:4",
+		}, problemBody(t, rec))
+	})
+
+	t.Run("problem error derives status from wrapped error", func(t *testing.T) {
+		err := &ProblemError{Err: NewError("busy", true), Type: "https://nuts.nl/problems/busy", RetryAfter: 1500 * time.Millisecond}
+
+		rec := serveError(http.MethodGet, err)
+
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Equal(t, "2", rec.Header().Get("Retry-After"))
+		assert.Equal(t, "busy", problemBody(t, rec)["detail"])
+	})
+
+	t.Run("Retry-After for too many requests", func(t *testing.T) {
+		err := &ProblemError{Err: NewError("slow down", true), Status: http.StatusTooManyRequests}
+
+		rec := serveError(http.MethodGet, err)
+
+		assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+		assert.Equal(t, "30", rec.Header().Get("Retry-After"))
+	})
+
+	t.Run("no body for HEAD requests", func(t *testing.T) {
+		rec := serveError(http.MethodHead, echo.ErrNotFound)
+
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.Empty(t, rec.Body.String())
+	})
+
+	t.Run("unknown routes", func(t *testing.T) {
+		ctl := NewEngineControl()
+		server, _ := NewHTTPServer(ctl, NewNutsGlobalConfig())
+		rec := httptest.NewRecorder()
+
+		server.Echo().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/unknown", nil))
+
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.Equal(t, "Not Found", problemBody(t, rec)["title"])
+	})
+}
@@ -0,0 +1,344 @@
+/*
+ * Nuts go core
+ * Copyright (C) 2020 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// ErrRestartRequired can be returned (wrapped) by an engine's Reconfigure hook when the changed config can only be applied by a restart
+var ErrRestartRequired = errors.New("restart required")
+
+// ReloadResult describes the outcome of NutsGlobalConfig.Reload
+type ReloadResult struct {
+	// Changed holds the config keys of which the value changed
+	Changed []string `json:"changed"`
+	// Reconfigured holds the names of the engines which applied the changed config
+	Reconfigured []string `json:"reconfigured"`
+	// RestartRequired holds the changed config keys that can't be applied without a restart
+	RestartRequired []string `json:"restartRequired"`
+}
+
+// Reload reads the config file again and applies the changed values. Changes to the verbosity and shutdowntimeout
+// global flags are applied directly. For every engine with changed values, the values are injected into a fresh copy
+// of the engine's Config which is passed to its Reconfigure hook. Changed keys of engines without Reconfigure
+// and changed keys which don't belong to any engine are reported as requiring a restart.
+// Changed values are only visible to readers of the config once they're applied: keys requiring a restart and keys
+// of which the change was rejected keep their previous value and are reported again by the next reload.
+// Engine errors are returned as EngineErrors, the other engines are reconfigured regardless.
+func (ngc *NutsGlobalConfig) Reload(engines *EngineControl) (ReloadResult, error) {
+	ngc.state.reload.Lock()
+	defer ngc.state.reload.Unlock()
+
+	result := ReloadResult{Changed: []string{}, Reconfigured: []string{}, RestartRequired: []string{}}
+	changed, err := ngc.readConfigFile()
+	if err != nil {
+		return result, fmt.Errorf("unable to reload config file: %w", err)
+	}
+	result.Changed = changed
+	if len(result.Changed) == 0 {
+		return result, nil
+	}
+
+	read := ngc.read()
+	if err := ngc.applyGlobal(engines, read, result.Changed, &result); err != nil {
+		return result, err
+	}
+
+	ordered, err := engines.StartOrder()
+	if err != nil {
+		return result, err
+	}
+	var errs EngineErrors
+	for _, e := range ordered {
+		keys := ngc.engineKeys(e, result.Changed)
+		if len(keys) == 0 {
+			continue
+		}
+		reconfigured, err := read.reconfigure(e)
+		switch {
+		case reconfigured:
+			ngc.apply(keys...)
+			result.Reconfigured = append(result.Reconfigured, e.Name)
+		case err == nil || errors.Is(err, ErrRestartRequired):
+			result.RestartRequired = append(result.RestartRequired, keys...)
+		default:
+			errs = append(errs, EngineError{Engine: e.Name, Phase: ReconfigurePhase, Err: err})
+		}
+	}
+	sort.Strings(result.RestartRequired)
+
+	if len(result.RestartRequired) > 0 {
+		log.Warnf("Config reloaded, changes to %s require a restart", strings.Join(result.RestartRequired, ", "))
+	}
+	if len(errs) > 0 {
+		return result, errs
+	}
+	return result, nil
+}
+
+// readConfigFile reads the config file again and returns the keys of which the value changed. The changed keys are
+// pinned at their previous value until they're applied, see apply.
+func (ngc *NutsGlobalConfig) readConfigFile() ([]string, error) {
+	before := ngc.settings()
+	ngc.state.values.Lock()
+	defer ngc.state.values.Unlock()
+	if err := ngc.v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	after := map[string]interface{}{}
+	for _, key := range ngc.v.AllKeys() {
+		after[key] = ngc.v.Get(key)
+	}
+	changed := changedKeys(before, after)
+	pinned := make(map[string]interface{}, len(changed))
+	for _, key := range changed {
+		pinned[key] = before[key]
+	}
+	ngc.pin(pinned)
+	return changed, nil
+}
+
+// read returns a view of the config holding the values as read from the config file, including the changes that
+// aren't applied yet. It shares the viper instance, which is only replaced by Reload, so it may only be used while reloading.
+func (ngc *NutsGlobalConfig) read() *NutsGlobalConfig {
+	read := *ngc
+	read.state = &configState{}
+	return &read
+}
+
+// apply makes the read values of the given keys visible to readers of the config
+func (ngc *NutsGlobalConfig) apply(keys ...string) {
+	ngc.state.values.Lock()
+	defer ngc.state.values.Unlock()
+	pinned := make(map[string]interface{}, len(ngc.state.pinned))
+	for key, value := range ngc.state.pinned {
+		pinned[key] = value
+	}
+	for _, key := range keys {
+		delete(pinned, key)
+	}
+	ngc.pin(pinned)
+}
+
+// pin replaces the pinned values, the caller must hold the values lock
+func (ngc *NutsGlobalConfig) pin(pinned map[string]interface{}) {
+	pins := viper.New()
+	for key, value := range pinned {
+		if value != nil {
+			pins.Set(key, value)
+		}
+	}
+	ngc.state.pinned = pinned
+	ngc.state.pins = pins
+}
+
+// applyGlobal applies the reloadable global flags and records changed keys that aren't owned by an engine as restart required
+func (ngc *NutsGlobalConfig) applyGlobal(engines *EngineControl, read *NutsGlobalConfig, changed []string, result *ReloadResult) error {
+	owned := map[string]bool{}
+	for _, e := range engines.All() {
+		for _, key := range ngc.engineKeys(e, changed) {
+			owned[key] = true
+		}
+	}
+	for _, key := range changed {
+		switch {
+		case key == loggerLevelFlag:
+			level, err := log.ParseLevel(read.getString(loggerLevelFlag))
+			if err != nil {
+				return fmt.Errorf("unable to reload %s: %w", loggerLevelFlag, err)
+			}
+			log.SetLevel(level)
+			ngc.apply(key)
+		case key == shutdownTimeoutFlag:
+			engines.SetShutdownTimeout(read.ShutdownTimeout())
+			ngc.apply(key)
+		case !owned[key]:
+			result.RestartRequired = append(result.RestartRequired, key)
+		}
+	}
+	return nil
+}
+
+// reconfigure injects the current config values into a copy of the engine's config and passes it to the engine's Reconfigure hook.
+// It returns false when the engine can't be reconfigured at runtime.
+func (ngc *NutsGlobalConfig) reconfigure(e *Engine) (bool, error) {
+	if e.Reconfigure == nil {
+		return false, nil
+	}
+	current := reflect.ValueOf(e.Config)
+	if current.Kind() != reflect.Ptr || current.Elem().Kind() != reflect.Struct {
+		return false, nil
+	}
+	fresh := reflect.New(current.Elem().Type())
+	fresh.Elem().Set(current.Elem())
+
+	target := *e
+	target.Config = fresh.Interface()
+	if err := ngc.InjectIntoEngine(&target); err != nil {
+		return false, err
+	}
+	if err := e.Reconfigure(target.Config); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// engineKeys returns the keys of the given changed keys that belong to the engine's flags
+func (ngc *NutsGlobalConfig) engineKeys(e *Engine, changed []string) []string {
+	if e.FlagSet == nil {
+		return nil
+	}
+	own := map[string]bool{}
+	e.FlagSet.VisitAll(func(f *pflag.Flag) {
+		own[strings.ToLower(ngc.configName(e, f))] = true
+	})
+	var keys []string
+	for _, key := range changed {
+		if own[key] {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// settings returns the current value of every config key
+func (ngc *NutsGlobalConfig) settings() map[string]interface{} {
+	values := map[string]interface{}{}
+	for _, key := range ngc.allKeys() {
+		values[key] = ngc.get(key)
+	}
+	return values
+}
+
+func changedKeys(before map[string]interface{}, after map[string]interface{}) []string {
+	changed := []string{}
+	for key, value := range after {
+		if !reflect.DeepEqual(before[key], value) {
+			changed = append(changed, key)
+		}
+	}
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// WatchConfigFile reloads the config (see Reload) whenever the config file is written, until the given context is done.
+// The directory of the config file is watched, so files replaced by editors or mounted from a Kubernetes ConfigMap are picked up as well.
+func (ngc *NutsGlobalConfig) WatchConfigFile(ctx context.Context, engines *EngineControl) error {
+	configFile := ngc.getString(configFileFlag)
+	if configFile == "" {
+		return errors.New("no config file configured")
+	}
+	configFile, err := filepath.Abs(configFile)
+	if err != nil {
+		return err
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	if err := watcher.Add(filepath.Dir(configFile)); err != nil {
+		return fmt.Errorf("unable to watch config file %s: %w", configFile, err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if filepath.Clean(event.Name) != configFile || event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+				continue
+			}
+			log.Infof("Config file %s changed, reloading", configFile)
+			ngc.logReload(engines)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Errorf("Error watching config file %s: %v", configFile, err)
+		}
+	}
+}
+
+// logReload reloads the config and logs the outcome, for reloads that aren't triggered by a caller that can handle the result
+func (ngc *NutsGlobalConfig) logReload(engines *EngineControl) {
+	result, err := ngc.Reload(engines)
+	if err != nil {
+		log.Errorf("Unable to reload config: %v", err)
+		return
+	}
+	log.Infof("Config reloaded, changed: %v, reconfigured engines: %v", result.Changed, result.Reconfigured)
+}
+
+// NewAdminEngine creates a new Engine for administering the node. It serves POST /admin/reload, which reloads
+// the config (see NutsGlobalConfig.Reload) and returns the ReloadResult as JSON. When reloading fails, the error is returned
+// as problem details with the members of the ReloadResult as extension members.
+func NewAdminEngine(config *NutsGlobalConfig, engines *EngineControl) *Engine {
+	return &Engine{
+		Name:        "Admin",
+		ConfigKey:   "admin",
+		AdminRoutes: true,
+		Routes: func(router EchoRouter) {
+			router.POST("/admin/reload", reloadConfig(config, engines))
+		},
+	}
+}
+
+func reloadConfig(config *NutsGlobalConfig, engines *EngineControl) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		result, err := config.Reload(engines)
+		if err != nil {
+			err = fmt.Errorf("unable to reload config: %w", err)
+			return &ProblemError{
+				Err:    err,
+				Status: http.StatusInternalServerError,
+				Detail: err.Error(),
+				Extensions: map[string]interface{}{
+					"changed":         result.Changed,
+					"reconfigured":    result.Reconfigured,
+					"restartRequired": result.RestartRequired,
+				},
+			}
+		}
+		return ctx.JSON(http.StatusOK, result)
+	}
+}
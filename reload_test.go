@@ -0,0 +1,343 @@
+/*
+ * Nuts go core
+ * Copyright (C) 2020 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+)
+
+type reloadableConfig struct {
+	Timeout int
+	Name    string
+}
+
+// reloadEngine returns an engine with config key "reload", reconfigured configs are sent to the given channel
+func reloadEngine(reconfigured chan reloadableConfig) *Engine {
+	fs := pflag.NewFlagSet("reload", pflag.ContinueOnError)
+	fs.Int("timeout", 1, "")
+	fs.String("name", "", "")
+	e := &Engine{
+		Name:      "Reload",
+		ConfigKey: "reload",
+		Config:    &reloadableConfig{},
+		FlagSet:   fs,
+	}
+	if reconfigured != nil {
+		e.Reconfigure = func(config interface{}) error {
+			c := config.(*reloadableConfig)
+			if c.Timeout < 0 {
+				return errors.New("timeout can't be negative")
+			}
+			if c.Name != e.Config.(*reloadableConfig).Name {
+				return fmt.Errorf("%w: name can't be changed", ErrRestartRequired)
+			}
+			reconfigured <- *c
+			return nil
+		}
+	}
+	return e
+}
+
+// reloadSetup writes the config file to a new temporary directory, loads it into a new config and injects it into the engine.
+// The caller must remove the directory.
+func reloadSetup(t *testing.T, e *Engine, contents string) (*NutsGlobalConfig, *EngineControl, string) {
+	dir, err := ioutil.TempDir("", "reload")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	file := filepath.Join(dir, "nuts.yaml")
+	writeConfig(t, file, contents)
+
+	cfg := NewNutsGlobalConfig()
+	cfg.v.Set(configFileFlag, file)
+	cfg.v.SetConfigFile(file)
+	if !assert.NoError(t, cfg.v.ReadInConfig()) {
+		t.FailNow()
+	}
+	ctl := NewEngineControl()
+	_ = ctl.Register(e)
+	cfg.RegisterFlags(&cobra.Command{}, e)
+	if !assert.NoError(t, cfg.InjectIntoEngine(e)) {
+		t.FailNow()
+	}
+	return cfg, ctl, file
+}
+
+func writeConfig(t *testing.T, file string, contents string) {
+	if !assert.NoError(t, ioutil.WriteFile(file, []byte(contents), 0644)) {
+		t.FailNow()
+	}
+}
+
+func TestNutsGlobalConfig_Reload(t *testing.T) {
+	t.Run("changed engine config is passed to Reconfigure", func(t *testing.T) {
+		reconfigured := make(chan reloadableConfig, 1)
+		e := reloadEngine(reconfigured)
+		cfg, ctl, file := reloadSetup(t, e, "reload:\n  timeout: 5\n  name: a\n")
+		defer os.RemoveAll(filepath.Dir(file))
+		writeConfig(t, file, "reload:\n  timeout: 10\n  name: a\n")
+
+		result, err := cfg.Reload(ctl)
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"reload.timeout"}, result.Changed)
+		assert.Equal(t, []string{"Reload"}, result.Reconfigured)
+		assert.Empty(t, result.RestartRequired)
+		assert.Equal(t, reloadableConfig{Timeout: 10, Name: "a"}, <-reconfigured)
+		assert.Equal(t, 5, e.Config.(*reloadableConfig).Timeout, "the engine's config is only changed by the engine")
+	})
+
+	t.Run("nothing happens when nothing changed", func(t *testing.T) {
+		reconfigured := make(chan reloadableConfig, 1)
+		cfg, ctl, file := reloadSetup(t, reloadEngine(reconfigured), "reload:\n  timeout: 5\n")
+		defer os.RemoveAll(filepath.Dir(file))
+
+		result, err := cfg.Reload(ctl)
+
+		assert.NoError(t, err)
+		assert.Empty(t, result.Changed)
+		assert.Empty(t, reconfigured)
+	})
+
+	t.Run("engine without Reconfigure requires restart", func(t *testing.T) {
+		cfg, ctl, file := reloadSetup(t, reloadEngine(nil), "reload:\n  timeout: 5\n")
+		defer os.RemoveAll(filepath.Dir(file))
+		writeConfig(t, file, "reload:\n  timeout: 10\n")
+
+		result, err := cfg.Reload(ctl)
+
+		assert.NoError(t, err)
+		assert.Empty(t, result.Reconfigured)
+		assert.Equal(t, []string{"reload.timeout"}, result.RestartRequired)
+	})
+
+	t.Run("engine returning ErrRestartRequired requires restart", func(t *testing.T) {
+		cfg, ctl, file := reloadSetup(t, reloadEngine(make(chan reloadableConfig, 1)), "reload:\n  name: a\n")
+		defer os.RemoveAll(filepath.Dir(file))
+		writeConfig(t, file, "reload:\n  name: b\n")
+
+		result, err := cfg.Reload(ctl)
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"reload.name"}, result.RestartRequired)
+	})
+
+	t.Run("invalid engine config is returned as error", func(t *testing.T) {
+		cfg, ctl, file := reloadSetup(t, reloadEngine(make(chan reloadableConfig, 1)), "reload:\n  timeout: 5\n")
+		defer os.RemoveAll(filepath.Dir(file))
+		writeConfig(t, file, "reload:\n  timeout: -1\n")
+
+		_, err := cfg.Reload(ctl)
+
+		assert.EqualError(t, err, "reconfigure of engine Reload failed: timeout can't be negative")
+		assert.Equal(t, 5, ctl.Get("Reload").Config.(*reloadableConfig).Timeout)
+	})
+
+	t.Run("global flags", func(t *testing.T) {
+		level := logrus.GetLevel()
+		defer logrus.SetLevel(level)
+		cfg, ctl, file := reloadSetup(t, reloadEngine(nil), "verbosity: info\nshutdowntimeout: 1s\naddress: localhost:1\n")
+		defer os.RemoveAll(filepath.Dir(file))
+		writeConfig(t, file, "verbosity: trace\nshutdowntimeout: 3s\naddress: localhost:2\n")
+
+		result, err := cfg.Reload(ctl)
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"address", "shutdowntimeout", "verbosity"}, result.Changed)
+		assert.Equal(t, []string{"address"}, result.RestartRequired)
+		assert.Equal(t, logrus.TraceLevel, logrus.GetLevel())
+		assert.Equal(t, 3*time.Second, ctl.ShutdownTimeout)
+	})
+
+	t.Run("invalid verbosity is returned as error", func(t *testing.T) {
+		cfg, ctl, file := reloadSetup(t, reloadEngine(nil), "verbosity: info\n")
+		defer os.RemoveAll(filepath.Dir(file))
+		writeConfig(t, file, "verbosity: loud\n")
+
+		_, err := cfg.Reload(ctl)
+
+		assert.Contains(t, err.Error(), "unable to reload verbosity")
+		assert.Equal(t, "info", cfg.getString(loggerLevelFlag))
+	})
+
+	t.Run("changes requiring a restart aren't applied", func(t *testing.T) {
+		cfg, ctl, file := reloadSetup(t, reloadEngine(nil), "strictmode: false\naddress: localhost:1\nreload:\n  timeout: 5\n")
+		defer os.RemoveAll(filepath.Dir(file))
+		writeConfig(t, file, "strictmode: true\naddress: localhost:2\nreload:\n  timeout: 10\n")
+
+		result, err := cfg.Reload(ctl)
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"address", "reload.timeout", "strictmode"}, result.RestartRequired)
+		assert.False(t, cfg.InStrictMode())
+		assert.Equal(t, "localhost:1", cfg.ServerAddress())
+		assert.Equal(t, 5, cfg.getInt("reload.timeout"))
+
+		result, err = cfg.Reload(ctl)
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"address", "reload.timeout", "strictmode"}, result.RestartRequired, "unapplied changes are reported again")
+		assert.False(t, cfg.InStrictMode())
+	})
+
+	t.Run("changes rejected by an engine aren't applied", func(t *testing.T) {
+		reconfigured := make(chan reloadableConfig, 1)
+		cfg, ctl, file := reloadSetup(t, reloadEngine(reconfigured), "reload:\n  timeout: 5\n")
+		defer os.RemoveAll(filepath.Dir(file))
+		writeConfig(t, file, "reload:\n  timeout: -1\n")
+
+		_, err := cfg.Reload(ctl)
+
+		assert.Error(t, err)
+		assert.Equal(t, 5, cfg.getInt("reload.timeout"))
+
+		writeConfig(t, file, "reload:\n  timeout: 10\n")
+		result, err := cfg.Reload(ctl)
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"reload.timeout"}, result.Changed)
+		assert.Equal(t, 10, (<-reconfigured).Timeout)
+		assert.Equal(t, 10, cfg.getInt("reload.timeout"))
+	})
+
+	t.Run("reverted changes aren't reported", func(t *testing.T) {
+		cfg, ctl, file := reloadSetup(t, reloadEngine(nil), "address: localhost:1\n")
+		defer os.RemoveAll(filepath.Dir(file))
+		writeConfig(t, file, "address: localhost:2\n")
+		_, _ = cfg.Reload(ctl)
+		writeConfig(t, file, "address: localhost:1\n")
+
+		result, err := cfg.Reload(ctl)
+
+		assert.NoError(t, err)
+		assert.Empty(t, result.Changed)
+		assert.Equal(t, "localhost:1", cfg.ServerAddress())
+	})
+
+	t.Run("error when config file can't be read", func(t *testing.T) {
+		cfg, ctl, file := reloadSetup(t, reloadEngine(nil), "verbosity: info\n")
+		defer os.RemoveAll(filepath.Dir(file))
+		writeConfig(t, file, "verbosity: [")
+
+		_, err := cfg.Reload(ctl)
+
+		assert.Contains(t, err.Error(), "unable to reload config file")
+	})
+
+	t.Run("config can be read while reloading", func(t *testing.T) {
+		cfg, ctl, file := reloadSetup(t, reloadEngine(nil), "shutdowntimeout: 1s\n")
+		defer os.RemoveAll(filepath.Dir(file))
+		writeConfig(t, file, "shutdowntimeout: 3s\n")
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_, _ = cfg.Reload(ctl)
+		}()
+		for i := 0; i < 100; i++ {
+			cfg.ShutdownTimeout()
+			ctl.shutdownTimeout(&Engine{})
+		}
+		<-done
+
+		assert.Equal(t, 3*time.Second, cfg.ShutdownTimeout())
+		assert.Equal(t, 3*time.Second, ctl.defaultShutdownTimeout())
+	})
+}
+
+func TestNutsGlobalConfig_WatchConfigFile(t *testing.T) {
+	reconfigured := make(chan reloadableConfig, 1)
+	cfg, ctl, file := reloadSetup(t, reloadEngine(reconfigured), "reload:\n  timeout: 5\n")
+	defer os.RemoveAll(filepath.Dir(file))
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		assert.NoError(t, cfg.WatchConfigFile(ctx, ctl))
+	}()
+	// give the watcher some time to start
+	time.Sleep(50 * time.Millisecond)
+
+	writeConfig(t, file, "reload:\n  timeout: 10\n")
+
+	select {
+	case c := <-reconfigured:
+		assert.Equal(t, 10, c.Timeout)
+	case <-time.After(5 * time.Second):
+		t.Error("config was not reloaded")
+	}
+	cancel()
+	wg.Wait()
+}
+
+func TestNewAdminEngine(t *testing.T) {
+	t.Run("reload returns the result", func(t *testing.T) {
+		reconfigured := make(chan reloadableConfig, 1)
+		cfg, ctl, file := reloadSetup(t, reloadEngine(reconfigured), "reload:\n  timeout: 5\n")
+		defer os.RemoveAll(filepath.Dir(file))
+		writeConfig(t, file, "reload:\n  timeout: 10\n")
+		e := echo.New()
+		NewAdminEngine(cfg, ctl).Routes(e)
+		rec := httptest.NewRecorder()
+
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/reload", nil))
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var result ReloadResult
+		_ = json.Unmarshal(rec.Body.Bytes(), &result)
+		assert.Equal(t, []string{"Reload"}, result.Reconfigured)
+	})
+
+	t.Run("reload returns 500 on error", func(t *testing.T) {
+		cfg, ctl, file := reloadSetup(t, reloadEngine(make(chan reloadableConfig, 1)), "reload:\n  timeout: 5\n")
+		defer os.RemoveAll(filepath.Dir(file))
+		writeConfig(t, file, "reload:\n  timeout: -1\n")
+		e := echo.New()
+		e.HTTPErrorHandler = ProblemErrorHandler
+		NewAdminEngine(cfg, ctl).Routes(e)
+		rec := httptest.NewRecorder()
+
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/reload", nil))
+
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		assert.Equal(t, ProblemContentType, rec.Header().Get(echo.HeaderContentType))
+		body := problemBody(t, rec)
+		assert.Equal(t, "unable to reload config: reconfigure of engine Reload failed: timeout can't be negative", body["detail"])
+		assert.Equal(t, []interface{}{"reload.timeout"}, body["changed"])
+	})
+}
@@ -0,0 +1,96 @@
+/*
+ * Nuts go core
+ * Copyright (C) 2020 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+// requestIDField is the name of the log field and problem details member holding the request ID
+const requestIDField = "requestId"
+
+// maxRequestIDLength is the maximum length of a request ID accepted from a client
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// RequestID is an echo middleware that correlates everything happening during a request. It takes the request ID from the
+// X-Request-ID header or, when absent or invalid, generates one. The ID is returned in the X-Request-ID response header and
+// stored in the context of the request, see RequestIDFrom and Log.
+func RequestID(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id := c.Request().Header.Get(echo.HeaderXRequestID)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		c.Response().Header().Set(echo.HeaderXRequestID, id)
+		c.SetRequest(c.Request().WithContext(WithRequestID(c.Request().Context(), id)))
+		return next(c)
+	}
+}
+
+// WithRequestID returns a copy of the context holding the request ID, e.g. to correlate work started outside an HTTP request
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFrom returns the request ID stored in the context, an empty string when there is none
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Log returns a logger for the given context. Entries logged with it contain the request ID of the context, if any.
+// Engines should log through it while handling a request:
+//  core.Log(c.Request().Context()).Infof("Vendor %s registered", id)
+func Log(ctx context.Context) *log.Entry {
+	if id := RequestIDFrom(ctx); id != "" {
+		return log.WithField(requestIDField, id)
+	}
+	return log.NewEntry(log.StandardLogger())
+}
+
+// validRequestID checks the request ID supplied by a client, so it can't be abused to inject content into logs or responses
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.', r == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Warnf("Unable to generate request ID: %v", err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,128 @@
+/*
+ * Nuts go core
+ * Copyright (C) 2020 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package core
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+// serveWithRequestID serves a request with the given X-Request-ID header through the RequestID middleware
+func serveWithRequestID(id string, handler echo.HandlerFunc) *httptest.ResponseRecorder {
+	e := echo.New()
+	e.HTTPErrorHandler = ProblemErrorHandler
+	e.Use(RequestID, RequestLogger)
+	e.GET("/", handler)
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if id != "" {
+		req.Header.Set(echo.HeaderXRequestID, id)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRequestID(t *testing.T) {
+	t.Run("request ID of the client is used", func(t *testing.T) {
+		var fromContext string
+		rec := serveWithRequestID("abc-123", func(c echo.Context) error {
+			fromContext = RequestIDFrom(c.Request().Context())
+			return c.NoContent(http.StatusNoContent)
+		})
+
+		assert.Equal(t, "abc-123", fromContext)
+		assert.Equal(t, "abc-123", rec.Header().Get(echo.HeaderXRequestID))
+	})
+
+	t.Run("request ID is generated when absent", func(t *testing.T) {
+		var fromContext string
+		rec := serveWithRequestID("", func(c echo.Context) error {
+			fromContext = RequestIDFrom(c.Request().Context())
+			return c.NoContent(http.StatusNoContent)
+		})
+
+		assert.Len(t, fromContext, 32)
+		assert.Equal(t, fromContext, rec.Header().Get(echo.HeaderXRequestID))
+	})
+
+	t.Run("invalid request ID is replaced", func(t *testing.T) {
+		for _, id := range []string{"id with spaces", "id\nwith newline", strings.Repeat("a", maxRequestIDLength+1)} {
+			rec := serveWithRequestID(id, func(c echo.Context) error {
+				return c.NoContent(http.StatusNoContent)
+			})
+
+			assert.Len(t, rec.Header().Get(echo.HeaderXRequestID), 32)
+		}
+	})
+
+	t.Run("request ID is part of error responses", func(t *testing.T) {
+		rec := serveWithRequestID("abc-123", func(c echo.Context) error {
+			return NewError("database unavailable", true)
+		})
+
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Equal(t, "abc-123", rec.Header().Get(echo.HeaderXRequestID))
+		assert.Equal(t, "abc-123", problemBody(t, rec)["requestId"])
+	})
+
+	t.Run("request ID is logged", func(t *testing.T) {
+		level := logrus.GetLevel()
+		defer logrus.SetLevel(level)
+		logrus.SetLevel(logrus.DebugLevel)
+		hook := test.NewGlobal()
+		defer hook.Reset()
+
+		serveWithRequestID("abc-123", func(c echo.Context) error {
+			Log(c.Request().Context()).Info("handling request")
+			return c.NoContent(http.StatusNoContent)
+		})
+
+		entries := hook.AllEntries()
+		if !assert.Len(t, entries, 2) {
+			return
+		}
+		assert.Equal(t, "handling request", entries[0].Message)
+		assert.Equal(t, "abc-123", entries[0].Data["requestId"])
+		assert.Equal(t, "HTTP request", entries[1].Message)
+		assert.Equal(t, "abc-123", entries[1].Data["requestId"])
+	})
+}
+
+func TestLog(t *testing.T) {
+	t.Run("without request ID", func(t *testing.T) {
+		entry := Log(context.Background())
+
+		assert.Empty(t, entry.Data)
+	})
+
+	t.Run("with request ID", func(t *testing.T) {
+		entry := Log(WithRequestID(context.Background(), "abc-123"))
+
+		assert.Equal(t, logrus.Fields{"requestId": "abc-123"}, entry.Data)
+	})
+}
@@ -0,0 +1,200 @@
+/*
+ * Nuts go core
+ * Copyright (C) 2020 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ErrRouteCollision is returned when two engines register a route with the same method and path
+var ErrRouteCollision = errors.New("route collision")
+
+// notFoundRouteName is the name of the catch-all routes echo adds for groups with middleware, these aren't part of the route table
+var notFoundRouteName = echo.New().GET("/", echo.NotFoundHandler).Name
+
+// RouteInfo describes a route registered by an engine
+type RouteInfo struct {
+	// Method is the HTTP method of the route
+	Method string `json:"method"`
+	// Path is the path of the route, including the engine's prefix when its routes are scoped
+	Path string `json:"path"`
+	// Engine is the name of the engine that registered the route
+	Engine string `json:"engine"`
+}
+
+// RouteTable returns the routes of the enabled engines, without starting a server. It returns an error when the engines
+// can't be ordered or when two engines register the same route, see NewHTTPServer.
+func RouteTable(engines *EngineControl) ([]RouteInfo, error) {
+	ordered, err := engines.StartOrder()
+	if err != nil {
+		return nil, err
+	}
+	return registerRoutes(echo.New(), ordered, nil)
+}
+
+// Routes returns the route table of the HTTP server created for this EngineControl, nil when no server has been created
+func (ec *EngineControl) Routes() []RouteInfo {
+	ec.stateMutex.RLock()
+	defer ec.stateMutex.RUnlock()
+	return append([]RouteInfo(nil), ec.routes...)
+}
+
+func (ec *EngineControl) setRoutes(routes []RouteInfo) {
+	ec.stateMutex.Lock()
+	defer ec.stateMutex.Unlock()
+	ec.routes = routes
+}
+
+// registerRoutes registers the routes of the engines, in the given order, and returns the resulting route table sorted by path and method.
+// Engines with ScopedRoutes get a router for the /<ConfigKey> prefix. When middleware is not nil, the middleware it returns
+// for an engine and whether a route is public is added to every route of the engine, see engineRouter.
+func registerRoutes(e *echo.Echo, engines []*Engine, middleware func(engine *Engine, public bool) []echo.MiddlewareFunc) ([]RouteInfo, error) {
+	registered := map[string]*echo.Route{}
+	owners := map[string]string{}
+	for _, engine := range engines {
+		if engine.Routes == nil {
+			continue
+		}
+		var router EchoRouter = e
+		if engine.ScopedRoutes && engine.ConfigKey != "" {
+			router = e.Group("/" + engine.ConfigKey)
+		}
+		r := &engineRouter{router: router}
+		if middleware != nil {
+			engine := engine
+			r.middleware = func(public bool) []echo.MiddlewareFunc {
+				return middleware(engine, public)
+			}
+		}
+		engine.Routes(r)
+
+		// echo keeps a single route per method and path: a route registered again replaces the previous one
+		for _, r := range e.Routes() {
+			key := r.Method + " " + r.Path
+			if r.Name == notFoundRouteName || registered[key] == r {
+				continue
+			}
+			if owner, ok := owners[key]; ok && owner != engine.Name {
+				return nil, fmt.Errorf("%w: %s registered by %s and %s", ErrRouteCollision, key, owner, engine.Name)
+			}
+			registered[key] = r
+			owners[key] = engine.Name
+		}
+	}
+
+	table := make([]RouteInfo, 0, len(registered))
+	for key, r := range registered {
+		table = append(table, RouteInfo{Method: r.Method, Path: r.Path, Engine: owners[key]})
+	}
+	sortRoutes(table)
+	return table, nil
+}
+
+// sortRoutes sorts the route table by path and method
+func sortRoutes(table []RouteInfo) {
+	sort.Slice(table, func(i, j int) bool {
+		if table[i].Path != table[j].Path {
+			return table[i].Path < table[j].Path
+		}
+		return table[i].Method < table[j].Method
+	})
+}
+
+// engineRouter is the EchoRouter passed to an engine. It adds middleware to every route of the engine: first the given
+// middleware (e.g. authentication and limits), then the middleware the engine added with Use and finally the middleware of
+// the route itself. Use only applies to the routes of the engine registered afterwards, instead of all routes of the server.
+// Routes and groups are marked public by passing Public as middleware, this is recorded when they are registered.
+type engineRouter struct {
+	router     EchoRouter
+	middleware func(public bool) []echo.MiddlewareFunc
+	used       []echo.MiddlewareFunc
+}
+
+func (r *engineRouter) with(m []echo.MiddlewareFunc) []echo.MiddlewareFunc {
+	own := make([]echo.MiddlewareFunc, 0, len(m))
+	public := false
+	for _, f := range m {
+		if isPublic(f) {
+			public = true
+		} else {
+			own = append(own, f)
+		}
+	}
+	var result []echo.MiddlewareFunc
+	if r.middleware != nil {
+		result = append(result, r.middleware(public)...)
+	}
+	return append(append(result, r.used...), own...)
+}
+
+func (r *engineRouter) Any(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) []*echo.Route {
+	return r.router.Any(path, h, r.with(m)...)
+}
+
+func (r *engineRouter) CONNECT(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route {
+	return r.router.CONNECT(path, h, r.with(m)...)
+}
+
+func (r *engineRouter) DELETE(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route {
+	return r.router.DELETE(path, h, r.with(m)...)
+}
+
+func (r *engineRouter) GET(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route {
+	return r.router.GET(path, h, r.with(m)...)
+}
+
+// Group creates a route group with the given middleware. The middleware of the engine is added to the group, so it runs
+// before the middleware of the routes in the group: mark the group public instead of its routes.
+func (r *engineRouter) Group(prefix string, m ...echo.MiddlewareFunc) *echo.Group {
+	return r.router.Group(prefix, r.with(m)...)
+}
+
+func (r *engineRouter) HEAD(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route {
+	return r.router.HEAD(path, h, r.with(m)...)
+}
+
+func (r *engineRouter) OPTIONS(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route {
+	return r.router.OPTIONS(path, h, r.with(m)...)
+}
+
+func (r *engineRouter) PATCH(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route {
+	return r.router.PATCH(path, h, r.with(m)...)
+}
+
+func (r *engineRouter) POST(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route {
+	return r.router.POST(path, h, r.with(m)...)
+}
+
+func (r *engineRouter) PUT(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route {
+	return r.router.PUT(path, h, r.with(m)...)
+}
+
+func (r *engineRouter) TRACE(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route {
+	return r.router.TRACE(path, h, r.with(m)...)
+}
+
+// Use adds middleware to the routes of the engine registered afterwards
+func (r *engineRouter) Use(m ...echo.MiddlewareFunc) {
+	r.used = append(r.used, m...)
+}
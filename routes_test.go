@@ -0,0 +1,295 @@
+/*
+ * Nuts go core
+ * Copyright (C) 2020 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func routesEngine(name string, configKey string, paths ...string) *Engine {
+	return &Engine{
+		Name:      name,
+		ConfigKey: configKey,
+		Routes: func(router EchoRouter) {
+			for _, path := range paths {
+				router.GET(path, StatusOK)
+			}
+		},
+	}
+}
+
+func TestRouteTable(t *testing.T) {
+	t.Run("routes are recorded with their engine", func(t *testing.T) {
+		ctl := NewEngineControl()
+		ctl.Register(routesEngine("A", "a", "/b", "/a"))
+		ctl.Register(&Engine{Name: "B", Routes: func(router EchoRouter) {
+			router.POST("/a", StatusOK)
+		}})
+
+		routes, err := RouteTable(ctl)
+
+		assert.NoError(t, err)
+		assert.Equal(t, []RouteInfo{
+			{Method: http.MethodGet, Path: "/a", Engine: "A"},
+			{Method: http.MethodPost, Path: "/a", Engine: "B"},
+			{Method: http.MethodGet, Path: "/b", Engine: "A"},
+		}, routes)
+		assert.Nil(t, ctl.Routes(), "route table is only stored for the server")
+	})
+
+	t.Run("scoped routes are prefixed with the ConfigKey", func(t *testing.T) {
+		ctl := NewEngineControl()
+		a := routesEngine("A", "a", "/status", "")
+		a.ScopedRoutes = true
+		ctl.Register(a)
+		ctl.Register(routesEngine("B", "b", "/status"))
+
+		routes, err := RouteTable(ctl)
+
+		assert.NoError(t, err)
+		assert.Equal(t, []RouteInfo{
+			{Method: http.MethodGet, Path: "/a", Engine: "A"},
+			{Method: http.MethodGet, Path: "/a/status", Engine: "A"},
+			{Method: http.MethodGet, Path: "/status", Engine: "B"},
+		}, routes)
+	})
+
+	t.Run("group middleware doesn't add routes", func(t *testing.T) {
+		ctl := NewEngineControl()
+		a := routesEngine("A", "a", "/x")
+		a.ScopedRoutes = true
+		routes := a.Routes
+		a.Routes = func(router EchoRouter) {
+			router.Group("/y", DecodeURIPath).GET("/z", StatusOK)
+			routes(router)
+		}
+		ctl.Register(a)
+
+		table, err := RouteTable(ctl)
+
+		assert.NoError(t, err)
+		assert.Equal(t, []RouteInfo{
+			{Method: http.MethodGet, Path: "/a/x", Engine: "A"},
+			{Method: http.MethodGet, Path: "/a/y/z", Engine: "A"},
+		}, table)
+	})
+
+	t.Run("error on collision", func(t *testing.T) {
+		ctl := NewEngineControl()
+		ctl.Register(routesEngine("A", "a", "/status"))
+		ctl.Register(routesEngine("B", "b", "/other", "/status"))
+
+		_, err := RouteTable(ctl)
+
+		assert.True(t, errors.Is(err, ErrRouteCollision))
+		assert.EqualError(t, err, "route collision: GET /status registered by A and B")
+	})
+
+	t.Run("engine may register its own route twice", func(t *testing.T) {
+		ctl := NewEngineControl()
+		ctl.Register(routesEngine("A", "a", "/status", "/status"))
+
+		routes, err := RouteTable(ctl)
+
+		assert.NoError(t, err)
+		assert.Len(t, routes, 1)
+	})
+}
+
+func TestNewHTTPServer_Routes(t *testing.T) {
+	t.Run("route table is stored", func(t *testing.T) {
+		ctl := NewEngineControl()
+		ctl.Register(NewStatusEngineFor(ctl))
+		server, err := NewHTTPServer(ctl, NewNutsGlobalConfig())
+		if !assert.NoError(t, err) {
+			return
+		}
+		rec := httptest.NewRecorder()
+
+		server.Echo().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status/routes", nil))
+
+		var routes []RouteInfo
+		_ = json.Unmarshal(rec.Body.Bytes(), &routes)
+		assert.Len(t, routes, 7)
+		assert.Equal(t, RouteInfo{Method: http.MethodGet, Path: "/status", Engine: "Status"}, routes[0])
+		assert.Equal(t, routes, ctl.Routes())
+	})
+
+	t.Run("error on collision", func(t *testing.T) {
+		ctl := NewEngineControl()
+		ctl.Register(routesEngine("A", "a", "/status"))
+		ctl.Register(routesEngine("B", "b", "/status"))
+
+		_, err := NewHTTPServer(ctl, NewNutsGlobalConfig())
+
+		assert.True(t, errors.Is(err, ErrRouteCollision))
+	})
+}
+
+// oapiRouter is the router interface of oapi-codegen generated RegisterHandlers functions
+type oapiRouter interface {
+	CONNECT(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route
+	DELETE(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route
+	GET(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route
+	HEAD(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route
+	OPTIONS(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route
+	PATCH(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route
+	POST(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route
+	PUT(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route
+	TRACE(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route
+}
+
+var _ oapiRouter = EchoRouter(nil)
+var _ EchoRouter = echo.New()
+var _ EchoRouter = echo.New().Group("/")
+
+// headerMiddleware adds the given response header, to check which middleware a request passed
+func headerMiddleware(name string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Response().Header().Add("X-Middleware", name)
+			return next(c)
+		}
+	}
+}
+
+func TestEngineRouter(t *testing.T) {
+	serve := func(server *HTTPServer, method string, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		server.Echo().ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+
+	t.Run("Use only applies to the routes of the engine", func(t *testing.T) {
+		ctl := NewEngineControl()
+		ctl.Register(&Engine{Name: "A", Routes: func(router EchoRouter) {
+			router.GET("/a/before", StatusOK)
+			router.Use(headerMiddleware("a"))
+			router.GET("/a/after", StatusOK)
+		}})
+		ctl.Register(routesEngine("B", "b", "/b"))
+		server, _ := NewHTTPServer(ctl, NewNutsGlobalConfig())
+
+		assert.Empty(t, serve(server, http.MethodGet, "/a/before").Header()["X-Middleware"])
+		assert.Equal(t, []string{"a"}, serve(server, http.MethodGet, "/a/after").Header()["X-Middleware"])
+		assert.Empty(t, serve(server, http.MethodGet, "/b").Header()["X-Middleware"])
+	})
+
+	t.Run("Use runs after authentication", func(t *testing.T) {
+		cfg := NewNutsGlobalConfig()
+		cfg.v.Set(authEnforceFlag, true)
+		ctl := NewEngineControl()
+		var principal *Principal
+		ctl.Register(&Engine{Name: "A", Routes: func(router EchoRouter) {
+			router.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+				return func(c echo.Context) error {
+					principal = PrincipalFrom(c.Request().Context())
+					return next(c)
+				}
+			})
+			router.GET("/a", StatusOK)
+		}})
+		server, _ := NewHTTPServer(ctl, cfg)
+		server.Authentication().Add(NewTokenAuthenticator(map[string]string{"secret": "alice"}))
+		req := httptest.NewRequest(http.MethodGet, "/a", nil)
+		req.Header.Set(APIKeyHeader, "secret")
+
+		server.Echo().ServeHTTP(httptest.NewRecorder(), req)
+
+		if assert.NotNil(t, principal) {
+			assert.Equal(t, "alice", principal.Name)
+		}
+	})
+
+	t.Run("route middleware runs after authentication", func(t *testing.T) {
+		cfg := NewNutsGlobalConfig()
+		cfg.v.Set(authEnforceFlag, true)
+		ctl := NewEngineControl()
+		ctl.Register(&Engine{Name: "A", Routes: func(router EchoRouter) {
+			router.GET("/protected", StatusOK, headerMiddleware("route"))
+			router.GET("/public", StatusOK, headerMiddleware("route"), Public)
+		}})
+		server, _ := NewHTTPServer(ctl, cfg)
+		server.Authentication().Add(ClientCertAuthenticator{})
+
+		rec := serve(server, http.MethodGet, "/protected")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Empty(t, rec.Header()["X-Middleware"])
+		rec = serve(server, http.MethodGet, "/public")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, []string{"route"}, rec.Header()["X-Middleware"])
+	})
+
+	t.Run("Any registers all methods", func(t *testing.T) {
+		ctl := NewEngineControl()
+		ctl.Register(&Engine{Name: "A", Routes: func(router EchoRouter) {
+			router.Any("/a", StatusOK)
+		}})
+		server, _ := NewHTTPServer(ctl, NewNutsGlobalConfig())
+
+		assert.Equal(t, http.StatusOK, serve(server, http.MethodDelete, "/a").Code)
+		assert.Len(t, ctl.Routes(), len(server.Echo().Routes()))
+	})
+
+	t.Run("Group has its own middleware", func(t *testing.T) {
+		cfg := NewNutsGlobalConfig()
+		cfg.v.Set(authEnforceFlag, true)
+		ctl := NewEngineControl()
+		ctl.Register(&Engine{Name: "A", ConfigKey: "a", ScopedRoutes: true, Routes: func(router EchoRouter) {
+			router.Use(headerMiddleware("a"))
+			public := router.Group("/public", Public, headerMiddleware("public"))
+			public.GET("/x", StatusOK)
+			router.Group("/protected").GET("/x", StatusOK)
+		}})
+		server, _ := NewHTTPServer(ctl, cfg)
+		server.Authentication().Add(ClientCertAuthenticator{})
+
+		rec := serve(server, http.MethodGet, "/a/public/x")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, []string{"a", "public"}, rec.Header()["X-Middleware"])
+		assert.Equal(t, http.StatusUnauthorized, serve(server, http.MethodGet, "/a/protected/x").Code)
+		assert.Equal(t, []RouteInfo{
+			{Method: http.MethodGet, Path: "/a/protected/x", Engine: "A"},
+			{Method: http.MethodGet, Path: "/a/public/x", Engine: "A"},
+		}, ctl.Routes())
+	})
+}
+
+func TestNewStatusEngine_RoutesCmd(t *testing.T) {
+	ctl := NewEngineControl()
+	ctl.Register(routesEngine("A", "a", "/a"))
+	cmd := NewStatusEngineFor(ctl).Cmd
+	buf := new(bytes.Buffer)
+	cmd.SetOut(buf)
+	cmd.SetArgs([]string{"routes"})
+
+	err := cmd.Execute()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "METHOD  PATH  ENGINE\nGET     /a    A\n", buf.String())
+}
@@ -0,0 +1,150 @@
+/*
+ * Nuts go core
+ * Copyright (C) 2020 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package core
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Runner runs a Nuts node: it starts the engines, serves their routes on the configured address and
+// shuts everything down in an orderly fashion when the process receives SIGINT or SIGTERM.
+// When Config is a *NutsGlobalConfig, the config is reloaded when the config file changes or the process receives SIGHUP.
+type Runner struct {
+	// Engines holds the engines to run
+	Engines *EngineControl
+	// Config holds the global configuration, it provides the server address and shutdown timeout
+	Config NutsConfigValues
+}
+
+// NewRunner creates a Runner for the given engines and configuration
+func NewRunner(engines *EngineControl, config NutsConfigValues) *Runner {
+	return &Runner{
+		Engines: engines,
+		Config:  config,
+	}
+}
+
+// Run starts the engines and the HTTP server and blocks until SIGINT or SIGTERM is received, see RunContext.
+func (r *Runner) Run() error {
+	return r.RunContext(context.Background())
+}
+
+// RunContext selects the engines to run (see EngineControl.SelectEngines), configures the ones that aren't configured yet,
+// starts them and the HTTP server and blocks until SIGINT or SIGTERM is received, the given
+// context is done or the HTTP server fails. It then stops accepting new requests, waits for in-flight requests
+// to complete (bound by the configured shutdown timeout) and shuts the engines down in reverse order.
+func (r *Runner) RunContext(ctx context.Context) error {
+	if r.Engines.defaultShutdownTimeout() == 0 {
+		r.Engines.SetShutdownTimeout(r.Config.ShutdownTimeout())
+	}
+	if err := r.Engines.SelectEngines(r.Config); err != nil {
+		return err
+	}
+	if err := r.Engines.Configure(); err != nil {
+		return err
+	}
+
+	server, err := NewHTTPServer(r.Engines, r.Config)
+	if err != nil {
+		return err
+	}
+
+	// trap signals before starting anything, so a signal during startup still results in an orderly shutdown
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	reloads := make(chan os.Signal, 1)
+	config, reloadable := r.Config.(*NutsGlobalConfig)
+	if reloadable {
+		signal.Notify(reloads, syscall.SIGHUP)
+		defer signal.Stop(reloads)
+	}
+
+	if err := r.Engines.StartContext(ctx); err != nil {
+		return err
+	}
+
+	if err := server.Start(); err != nil {
+		return r.shutdown(nil, err)
+	}
+
+	if reloadable {
+		watchCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go func() {
+			if err := config.WatchConfigFile(watchCtx, r.Engines); err != nil {
+				log.Warnf("Config file changes won't be applied: %v", err)
+			}
+		}()
+	}
+
+	for {
+		select {
+		case sig := <-reloads:
+			log.Infof("Received %s, reloading config", sig)
+			config.logReload(r.Engines)
+		case sig := <-signals:
+			log.Infof("Received %s, shutting down", sig)
+			return r.shutdown(server, nil)
+		case <-ctx.Done():
+			log.Info("Shutting down")
+			return r.shutdown(server, nil)
+		case err := <-server.Err():
+			// the other listener, if any, is still serving
+			return r.shutdown(server, err)
+		}
+	}
+}
+
+// shutdown stops the HTTP server (when given) and shuts down the engines. cause is the error that triggered the shutdown, if any.
+// The first error that occurred is returned, any subsequent errors are logged.
+func (r *Runner) shutdown(server *HTTPServer, cause error) error {
+	result := cause
+	record := func(err error) {
+		if result == nil {
+			result = err
+		} else {
+			log.Error(err)
+		}
+	}
+	if server != nil {
+		ctx, cancel := r.shutdownContext()
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			record(err)
+		}
+	}
+	if err := r.Engines.Shutdown(); err != nil {
+		record(err)
+	}
+	return result
+}
+
+func (r *Runner) shutdownContext() (context.Context, context.CancelFunc) {
+	if timeout := r.Engines.defaultShutdownTimeout(); timeout > 0 {
+		return context.WithTimeout(context.Background(), timeout)
+	}
+	return context.WithCancel(context.Background())
+}
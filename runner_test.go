@@ -0,0 +1,380 @@
+/*
+ * Nuts go core
+ * Copyright (C) 2020 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// freeAddress returns a local address with a port that is free at the moment of calling
+func freeAddress(t *testing.T) string {
+	l, err := net.Listen("tcp", "localhost:0")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func runnerConfig(address string) *NutsGlobalConfig {
+	cfg := NewNutsGlobalConfig()
+	cfg.v.Set(addressFlag, address)
+	cfg.v.Set(shutdownTimeoutFlag, time.Second)
+	return cfg
+}
+
+// testClient doesn't reuse connections, so no idle or unused connections are left behind that delay draining the server
+var testClient = &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+// waitForServer polls the given URL until it responds
+func waitForServer(t *testing.T, url string) {
+	for i := 0; i < 100; i++ {
+		if resp, err := testClient.Get(url); err == nil {
+			resp.Body.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("server at %s did not come up", url)
+}
+
+type syncCalls struct {
+	mutex sync.Mutex
+	calls []string
+}
+
+func (s *syncCalls) add(call string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.calls = append(s.calls, call)
+}
+
+func (s *syncCalls) get() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string{}, s.calls...)
+}
+
+func runnerEngine(name string, calls *syncCalls) *Engine {
+	return &Engine{
+		Name: name,
+		Start: func() error {
+			calls.add("start " + name)
+			return nil
+		},
+		Shutdown: func() error {
+			calls.add("shutdown " + name)
+			return nil
+		},
+		Routes: func(router EchoRouter) {
+			router.GET("/"+name, func(c echo.Context) error {
+				return c.String(http.StatusOK, name)
+			})
+		},
+	}
+}
+
+func TestRunner_RunContext(t *testing.T) {
+	t.Run("serves engine routes and shuts down when the context is done", func(t *testing.T) {
+		calls := &syncCalls{}
+		ctl := &EngineControl{}
+		ctl.Register(runnerEngine("a", calls))
+		ctl.Register(runnerEngine("b", calls))
+		address := freeAddress(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		result := make(chan error)
+		go func() {
+			result <- NewRunner(ctl, runnerConfig(address)).RunContext(ctx)
+		}()
+
+		waitForServer(t, fmt.Sprintf("http://%s/a", address))
+		resp, err := testClient.Get(fmt.Sprintf("http://%s/b", address))
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, "b", string(body))
+
+		cancel()
+
+		assert.NoError(t, <-result)
+		assert.Equal(t, []string{"start a", "start b", "shutdown b", "shutdown a"}, calls.get())
+		assert.Equal(t, time.Second, ctl.ShutdownTimeout)
+	})
+
+	t.Run("shuts down on SIGTERM", func(t *testing.T) {
+		calls := &syncCalls{}
+		ctl := &EngineControl{}
+		ctl.Register(runnerEngine("a", calls))
+		address := freeAddress(t)
+		result := make(chan error)
+		go func() {
+			result <- NewRunner(ctl, runnerConfig(address)).Run()
+		}()
+		waitForServer(t, fmt.Sprintf("http://%s/a", address))
+
+		p, _ := os.FindProcess(os.Getpid())
+		_ = p.Signal(syscall.SIGTERM)
+
+		select {
+		case err := <-result:
+			assert.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("runner did not stop")
+		}
+		assert.Equal(t, []string{"start a", "shutdown a"}, calls.get())
+	})
+
+	t.Run("drains in-flight requests", func(t *testing.T) {
+		ctl := &EngineControl{}
+		requestStarted := make(chan struct{})
+		ctl.Register(&Engine{
+			Name: "slow",
+			Routes: func(router EchoRouter) {
+				router.GET("/ping", StatusOK)
+				router.GET("/slow", func(c echo.Context) error {
+					close(requestStarted)
+					time.Sleep(100 * time.Millisecond)
+					return c.String(http.StatusOK, "done")
+				})
+			},
+		})
+		address := freeAddress(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		result := make(chan error)
+		go func() {
+			result <- NewRunner(ctl, runnerConfig(address)).RunContext(ctx)
+		}()
+		waitForServer(t, fmt.Sprintf("http://%s/ping", address))
+
+		status := make(chan int)
+		go func() {
+			resp, err := testClient.Get(fmt.Sprintf("http://%s/slow", address))
+			if err != nil {
+				status <- 0
+				return
+			}
+			resp.Body.Close()
+			status <- resp.StatusCode
+		}()
+		<-requestStarted
+		cancel()
+
+		assert.Equal(t, http.StatusOK, <-status)
+		assert.NoError(t, <-result)
+	})
+
+	t.Run("returns error when an engine fails to start", func(t *testing.T) {
+		ctl := &EngineControl{}
+		ctl.Register(&Engine{
+			Name: "a",
+			Start: func() error {
+				return errors.New("failed")
+			},
+		})
+
+		err := NewRunner(ctl, runnerConfig(freeAddress(t))).RunContext(context.Background())
+
+		assert.Equal(t, "start of engine a failed: failed", err.Error())
+	})
+
+	t.Run("shuts down engines when address can't be listened on", func(t *testing.T) {
+		calls := &syncCalls{}
+		ctl := &EngineControl{}
+		ctl.Register(runnerEngine("a", calls))
+		l, _ := net.Listen("tcp", "localhost:0")
+		defer l.Close()
+
+		err := NewRunner(ctl, runnerConfig(l.Addr().String())).RunContext(context.Background())
+
+		assert.Contains(t, err.Error(), "unable to listen on")
+		assert.Equal(t, []string{"start a", "shutdown a"}, calls.get())
+	})
+
+	t.Run("runs two independent nodes in one process", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var addresses []string
+		var results []chan error
+		for _, name := range []string{"node1", "node2"} {
+			ctl := NewEngineControl()
+			ctl.Register(NewStatusEngineFor(ctl))
+			ctl.Register(&Engine{Name: name})
+			address := freeAddress(t)
+			result := make(chan error)
+			go func() {
+				result <- NewRunner(ctl, runnerConfig(address)).RunContext(ctx)
+			}()
+			addresses = append(addresses, address)
+			results = append(results, result)
+		}
+
+		for i, address := range addresses {
+			waitForServer(t, fmt.Sprintf("http://%s/status", address))
+			resp, err := testClient.Get(fmt.Sprintf("http://%s/status/engines", address))
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+			var statuses []EngineStatus
+			_ = json.NewDecoder(resp.Body).Decode(&statuses)
+			resp.Body.Close()
+			assert.Len(t, statuses, 2)
+			assert.Equal(t, fmt.Sprintf("node%d", i+1), statuses[1].Name)
+			assert.Equal(t, EngineStarted, statuses[1].State)
+		}
+
+		cancel()
+		for _, result := range results {
+			assert.NoError(t, <-result)
+		}
+	})
+
+	t.Run("reloads config on SIGHUP", func(t *testing.T) {
+		reconfigured := make(chan reloadableConfig, 1)
+		e := reloadEngine(reconfigured)
+		e.Routes = func(router EchoRouter) {
+			router.GET("/ping", StatusOK)
+		}
+		cfg, ctl, file := reloadSetup(t, e, "reload:\n  timeout: 5\n")
+		defer os.RemoveAll(filepath.Dir(file))
+		address := freeAddress(t)
+		cfg.v.Set(addressFlag, address)
+		// change the file before the runner starts watching it, so only SIGHUP causes a reload
+		writeConfig(t, file, "reload:\n  timeout: 10\n")
+		ctx, cancel := context.WithCancel(context.Background())
+		result := make(chan error)
+		go func() {
+			result <- NewRunner(ctl, cfg).RunContext(ctx)
+		}()
+		waitForServer(t, fmt.Sprintf("http://%s/ping", address))
+
+		p, _ := os.FindProcess(os.Getpid())
+		_ = p.Signal(syscall.SIGHUP)
+
+		select {
+		case c := <-reconfigured:
+			assert.Equal(t, 10, c.Timeout)
+		case <-time.After(5 * time.Second):
+			t.Error("config was not reloaded")
+		}
+		cancel()
+		assert.NoError(t, <-result)
+	})
+
+	t.Run("doesn't run disabled engines", func(t *testing.T) {
+		calls := &syncCalls{}
+		ctl := NewEngineControl()
+		a := runnerEngine("a", calls)
+		a.Configure = func() error {
+			calls.add("configure a")
+			return nil
+		}
+		ctl.Register(a)
+		b := runnerEngine("b", calls)
+		b.ConfigKey = "b"
+		b.Configure = func() error {
+			calls.add("configure b")
+			return nil
+		}
+		ctl.Register(b)
+		address := freeAddress(t)
+		cfg := runnerConfig(address)
+		cfg.v.Set(disabledEnginesFlag, []string{"b"})
+		ctx, cancel := context.WithCancel(context.Background())
+		result := make(chan error)
+		go func() {
+			result <- NewRunner(ctl, cfg).RunContext(ctx)
+		}()
+		waitForServer(t, fmt.Sprintf("http://%s/a", address))
+
+		resp, err := testClient.Get(fmt.Sprintf("http://%s/b", address))
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		cancel()
+		assert.NoError(t, <-result)
+		assert.Equal(t, []string{"configure a", "start a", "shutdown a"}, calls.get())
+	})
+
+	t.Run("returns error when an engine fails to configure", func(t *testing.T) {
+		calls := &syncCalls{}
+		ctl := NewEngineControl()
+		e := runnerEngine("a", calls)
+		e.Configure = func() error {
+			return errors.New("configure failed")
+		}
+		ctl.Register(e)
+
+		err := NewRunner(ctl, runnerConfig(freeAddress(t))).RunContext(context.Background())
+
+		assert.EqualError(t, err, "configure of engine a failed: configure failed")
+		assert.Empty(t, calls.get())
+	})
+
+	t.Run("stops the admin listener when the other listener fails", func(t *testing.T) {
+		ctl := NewEngineControl()
+		ctl.Register(NewStatusEngineFor(ctl))
+		cfg := runnerConfig(freeAddress(t))
+		adminAddress := freeAddress(t)
+		cfg.v.Set(adminAddressFlag, adminAddress)
+		server, _ := NewHTTPServer(ctl, cfg)
+		if !assert.NoError(t, server.Start()) {
+			t.FailNow()
+		}
+		waitForServer(t, fmt.Sprintf("http://%s/status", adminAddress))
+		server.listener.Close()
+		cause := <-server.Err()
+
+		err := NewRunner(ctl, cfg).shutdown(server, cause)
+
+		assert.Equal(t, cause, err)
+		_, err = testClient.Get(fmt.Sprintf("http://%s/status", adminAddress))
+		assert.Error(t, err)
+	})
+
+	t.Run("returns error on dependency cycle", func(t *testing.T) {
+		ctl := &EngineControl{}
+		ctl.Register(&Engine{Name: "a", Dependencies: []string{"a"}})
+
+		err := NewRunner(ctl, runnerConfig(freeAddress(t))).RunContext(context.Background())
+
+		assert.True(t, errors.Is(err, ErrDependencyCycle))
+	})
+}
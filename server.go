@@ -0,0 +1,295 @@
+/*
+ * Nuts go core
+ * Copyright (C) 2020 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package core
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrServerNotStarted is returned when the HTTP server is used before it's started
+var ErrServerNotStarted = errors.New("HTTP server not started")
+
+// HTTPServer serves the routes of the enabled engines on the configured address, so every Nuts executable serves HTTP identically.
+// When an admin address is configured, the routes of engines with AdminRoutes are served on that address instead, so they
+// aren't exposed to the networks the node API is exposed to. Both addresses can be a unix domain socket, see UnixSocketScheme.
+// Requests pass the RequestID, RequestLogger, HTTPMetrics, Recover and DecodeURIPath middleware before reaching the engine's handler.
+// Requests for the routes of the engines are authenticated, see Authentication, and limited, see LimitOptions.
+// Errors returned by handlers are rendered as problem details by ProblemErrorHandler.
+type HTTPServer struct {
+	engines       *EngineControl
+	auth          *Authentication
+	config        NutsConfigValues
+	echo          *echo.Echo
+	adminEcho     *echo.Echo
+	servers       []*http.Server
+	listener      net.Listener
+	adminListener net.Listener
+	serveErr      chan error
+}
+
+// NewHTTPServer creates an HTTPServer and registers the routes of the enabled engines, in start order. The resulting
+// route table is available through EngineControl.Routes. It returns an error when the engines can't be ordered,
+// when the limits are invalid or when two engines register a route with the same method and path.
+func NewHTTPServer(engines *EngineControl, config NutsConfigValues) (*HTTPServer, error) {
+	auth, err := NewAuthentication(config.Auth())
+	if err != nil {
+		return nil, err
+	}
+	if isUnixSocket(config.ServerAddress()) || isUnixSocket(config.AdminAddress()) {
+		auth.Add(SocketAuthenticator{})
+	}
+	ordered, err := engines.StartOrder()
+	if err != nil {
+		return nil, err
+	}
+	limits, err := engineLimits(config, ordered)
+	if err != nil {
+		return nil, err
+	}
+	middleware := func(engine *Engine, public bool) []echo.MiddlewareFunc {
+		// limits apply before rejecting unauthenticated requests, so clients with invalid credentials are limited by IP address
+		return []echo.MiddlewareFunc{auth.identify, limits[engine], auth.enforce(public)}
+	}
+
+	public := ordered
+	var admin []*Engine
+	if config.AdminAddress() != "" {
+		public = nil
+		for _, engine := range ordered {
+			if engine.AdminRoutes {
+				admin = append(admin, engine)
+			} else {
+				public = append(public, engine)
+			}
+		}
+	}
+	e, routes, err := newEcho(public, middleware)
+	if err != nil {
+		return nil, err
+	}
+	server := &HTTPServer{
+		engines: engines,
+		auth:    auth,
+		config:  config,
+		echo:    e,
+		// one for each http.Server, so a failing server never blocks
+		serveErr: make(chan error, 2),
+	}
+	if config.AdminAddress() != "" {
+		adminEcho, adminRoutes, err := newEcho(admin, middleware)
+		if err != nil {
+			return nil, err
+		}
+		server.adminEcho = adminEcho
+		routes = append(routes, adminRoutes...)
+		sortRoutes(routes)
+	}
+	engines.setRoutes(routes)
+	return server, nil
+}
+
+// newEcho creates an echo instance serving the routes of the given engines and returns it with its route table
+func newEcho(engines []*Engine, middleware func(engine *Engine, public bool) []echo.MiddlewareFunc) (*echo.Echo, []RouteInfo, error) {
+	e := echo.New()
+	e.HideBanner = true
+	e.HidePort = true
+	e.HTTPErrorHandler = ProblemErrorHandler
+	// don't trust X-Forwarded-For and X-Real-IP headers, which any client can set, for the IP address clients are limited by
+	e.IPExtractor = echo.ExtractIPDirect()
+
+	routes, err := registerRoutes(e, engines, middleware)
+	if err != nil {
+		return nil, nil, err
+	}
+	// echo applies middleware when serving a request, so it applies to the routes registered above
+	e.Use(RequestID, RequestLogger, HTTPMetrics(routes), Recover, DecodeURIPath)
+	return e, routes, nil
+}
+
+// Echo returns the echo instance serving the routes, e.g. to add middleware or routes that don't belong to an engine.
+func (s *HTTPServer) Echo() *echo.Echo {
+	return s.echo
+}
+
+// AdminEcho returns the echo instance serving the administrative routes, nil when no admin address is configured.
+func (s *HTTPServer) AdminEcho() *echo.Echo {
+	return s.adminEcho
+}
+
+// Authentication returns the authentication of the routes of the engines, e.g. to add an Authenticator before the server is started.
+func (s *HTTPServer) Authentication() *Authentication {
+	return s.auth
+}
+
+// Start listens on the configured address, and the admin address when configured, and serves requests in the background.
+// Requests are served over TLS when configured, see TLSOptions, except on a unix domain socket. In strict mode, the server
+// refuses to start without TLS unless it only listens on unix domain sockets.
+// It refuses to start when authentication is enforced without authenticators, see AuthOptions.
+// Errors occurring while serving are sent to the channel returned by Err.
+func (s *HTTPServer) Start() error {
+	tlsOptions := s.config.TLS()
+	var tlsConfig *tls.Config
+	if tlsOptions.Enabled() {
+		var err error
+		if tlsConfig, err = tlsOptions.serverConfig(); err != nil {
+			return err
+		}
+	} else if s.config.InStrictMode() && s.listensOnTCP() {
+		return ErrPlainHTTPInStrictMode
+	}
+	if err := s.auth.check(); err != nil {
+		return err
+	}
+
+	listener, err := listen(s.config.ServerAddress(), tlsConfig)
+	if err != nil {
+		return err
+	}
+	var adminListener net.Listener
+	if s.adminEcho != nil {
+		if adminListener, err = listen(s.config.AdminAddress(), tlsConfig); err != nil {
+			listener.Close()
+			return err
+		}
+	}
+	s.listener, s.adminListener = listener, adminListener
+
+	s.serve(s.echo, listener)
+	log.Infof("Nuts node listening on %s (TLS: %t)", listener.Addr(), tlsConfig != nil && !isUnixSocket(s.config.ServerAddress()))
+	if adminListener != nil {
+		s.serve(s.adminEcho, adminListener)
+		log.Infof("Nuts node serving administrative routes on %s (TLS: %t)", adminListener.Addr(), tlsConfig != nil && !isUnixSocket(s.config.AdminAddress()))
+	}
+	return nil
+}
+
+// listensOnTCP returns whether the server or admin address isn't a unix domain socket
+func (s *HTTPServer) listensOnTCP() bool {
+	return !isUnixSocket(s.config.ServerAddress()) || s.adminEcho != nil && !isUnixSocket(s.config.AdminAddress())
+}
+
+// listen listens on the given address, over TLS when tlsConfig is given and the address isn't a unix domain socket
+func listen(address string, tlsConfig *tls.Config) (net.Listener, error) {
+	if isUnixSocket(address) {
+		return listenUnix(unixSocketPath(address))
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen on %s: %w", address, err)
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	return listener, nil
+}
+
+func (s *HTTPServer) serve(handler http.Handler, listener net.Listener) {
+	timeouts := s.config.Timeouts()
+	server := &http.Server{Handler: handler, ReadTimeout: timeouts.Read, WriteTimeout: timeouts.Write}
+	s.servers = append(s.servers, server)
+	go func() {
+		if err := server.Serve(listener); err != http.ErrServerClosed {
+			s.serveErr <- fmt.Errorf("HTTP server failed: %w", err)
+		}
+	}()
+}
+
+// Addr returns the address the server listens on, nil when the server isn't started
+func (s *HTTPServer) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// AdminAddr returns the address the administrative routes are served on, nil when the server isn't started or no admin address is configured
+func (s *HTTPServer) AdminAddr() net.Addr {
+	if s.adminListener == nil {
+		return nil
+	}
+	return s.adminListener.Addr()
+}
+
+// Err returns a channel receiving the errors that stopped the server from serving
+func (s *HTTPServer) Err() <-chan error {
+	return s.serveErr
+}
+
+// Shutdown stops accepting new requests and waits for in-flight requests to complete until the context is done.
+func (s *HTTPServer) Shutdown(ctx context.Context) error {
+	if len(s.servers) == 0 {
+		return ErrServerNotStarted
+	}
+	var result error
+	for _, server := range s.servers {
+		if err := server.Shutdown(ctx); err != nil && result == nil {
+			result = fmt.Errorf("unable to drain HTTP server: %w", err)
+		}
+	}
+	return result
+}
+
+// Recover is an echo middleware that converts a panic in a handler into an error, so the server keeps serving other requests
+func Recover(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				Log(c.Request().Context()).Errorf("Panic while handling %s %s: %v\n%s", c.Request().Method, c.Request().URL.Path, r, debug.Stack())
+				err = fmt.Errorf("panic: %v", r)
+			}
+		}()
+		return next(c)
+	}
+}
+
+// RequestLogger is an echo middleware that logs every request. Requests resulting in a server error are logged on error level,
+// other requests on debug level. Errors returned by the handler are passed to the echo error handler.
+func RequestLogger(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		start := time.Now()
+		if err := next(c); err != nil {
+			c.Error(err)
+		}
+		status := c.Response().Status
+		entry := Log(c.Request().Context()).WithFields(log.Fields{
+			"method":   c.Request().Method,
+			"uri":      c.Request().RequestURI,
+			"status":   status,
+			"duration": time.Since(start),
+			"remote":   c.RealIP(),
+		})
+		if status >= http.StatusInternalServerError {
+			entry.Error("HTTP request failed")
+		} else {
+			entry.Debug("HTTP request")
+		}
+		return nil
+	}
+}
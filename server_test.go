@@ -0,0 +1,278 @@
+/*
+ * Nuts go core
+ * Copyright (C) 2020 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestServer registers the engines and creates a server for them with the given config, failing the test when either fails
+func newTestServer(t *testing.T, cfg NutsConfigValues, engines ...*Engine) *HTTPServer {
+	ctl := NewEngineControl()
+	for _, engine := range engines {
+		if !assert.NoError(t, ctl.Register(engine)) {
+			t.FailNow()
+		}
+	}
+	server, err := NewHTTPServer(ctl, cfg)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return server
+}
+
+// principalName is a handler responding with the name of the principal of the request, anonymous without principal
+func principalName(c echo.Context) error {
+	name := "anonymous"
+	if principal := PrincipalFrom(c.Request().Context()); principal != nil {
+		name = principal.Name
+	}
+	return c.String(http.StatusOK, name)
+}
+
+func TestNewHTTPServer(t *testing.T) {
+	t.Run("routes of enabled engines are registered", func(t *testing.T) {
+		ctl := NewEngineControl()
+		ctl.Register(runnerEngine("a", &syncCalls{}))
+		b := runnerEngine("b", &syncCalls{})
+		b.ConfigKey = "b"
+		ctl.Register(b)
+		cfg := NewNutsGlobalConfig()
+		cfg.v.Set(disabledEnginesFlag, []string{"b"})
+		_ = ctl.SelectEngines(cfg)
+
+		server, err := NewHTTPServer(ctl, cfg)
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		var paths []string
+		for _, r := range server.Echo().Routes() {
+			paths = append(paths, r.Path)
+		}
+		assert.Equal(t, []string{"/a"}, paths)
+	})
+
+	t.Run("path parameters are decoded", func(t *testing.T) {
+		ctl := NewEngineControl()
+		ctl.Register(&Engine{Name: "a", Routes: func(router EchoRouter) {
+			router.GET("/a/:id", func(c echo.Context) error {
+				return c.String(http.StatusOK, c.Param("id"))
+			})
+		}})
+		server, _ := NewHTTPServer(ctl, NewNutsGlobalConfig())
+		rec := httptest.NewRecorder()
+
+		server.Echo().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/a/urn:oid:1.2.3%3A4", nil))
+
+		assert.Equal(t, "urn:oid:1.2.3:4", rec.Body.String())
+	})
+
+	t.Run("error on dependency cycle", func(t *testing.T) {
+		ctl := NewEngineControl()
+		ctl.Register(&Engine{Name: "a", Dependencies: []string{"a"}})
+
+		_, err := NewHTTPServer(ctl, NewNutsGlobalConfig())
+
+		assert.True(t, errors.Is(err, ErrDependencyCycle))
+	})
+}
+
+func TestHTTPServer_Start(t *testing.T) {
+	t.Run("serves until shut down", func(t *testing.T) {
+		ctl := NewEngineControl()
+		ctl.Register(runnerEngine("a", &syncCalls{}))
+		server, _ := NewHTTPServer(ctl, runnerConfig("localhost:0"))
+
+		err := server.Start()
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		resp, err := testClient.Get(fmt.Sprintf("http://%s/a", server.Addr()))
+		if !assert.NoError(t, err) {
+			return
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, "a", string(body))
+
+		assert.NoError(t, server.Shutdown(context.Background()))
+		assert.Empty(t, server.Err())
+	})
+
+	t.Run("error when address is in use", func(t *testing.T) {
+		l, _ := net.Listen("tcp", "localhost:0")
+		defer l.Close()
+		server, _ := NewHTTPServer(NewEngineControl(), runnerConfig(l.Addr().String()))
+
+		err := server.Start()
+
+		assert.Contains(t, err.Error(), "unable to listen on")
+		assert.Nil(t, server.Addr())
+	})
+
+	t.Run("error when admin address is in use", func(t *testing.T) {
+		l, _ := net.Listen("tcp", "localhost:0")
+		defer l.Close()
+		cfg := runnerConfig("localhost:0")
+		cfg.v.Set(adminAddressFlag, l.Addr().String())
+		server, _ := NewHTTPServer(NewEngineControl(), cfg)
+
+		err := server.Start()
+
+		assert.Contains(t, err.Error(), "unable to listen on "+l.Addr().String())
+		assert.Nil(t, server.Addr())
+		assert.Nil(t, server.AdminAddr())
+	})
+
+	t.Run("shutdown before start", func(t *testing.T) {
+		server, _ := NewHTTPServer(NewEngineControl(), runnerConfig("localhost:0"))
+
+		assert.True(t, errors.Is(server.Shutdown(context.Background()), ErrServerNotStarted))
+	})
+}
+
+func TestHTTPServer_AdminAddress(t *testing.T) {
+	engines := func() *EngineControl {
+		ctl := NewEngineControl()
+		ctl.Register(runnerEngine("a", &syncCalls{}))
+		admin := runnerEngine("admin", &syncCalls{})
+		admin.AdminRoutes = true
+		ctl.Register(admin)
+		return ctl
+	}
+	get := func(e *echo.Echo, path string) int {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	t.Run("administrative routes are served on the admin address", func(t *testing.T) {
+		ctl := engines()
+		cfg := runnerConfig("localhost:0")
+		cfg.v.Set(adminAddressFlag, "localhost:0")
+		server, err := NewHTTPServer(ctl, cfg)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		assert.Equal(t, http.StatusOK, get(server.Echo(), "/a"))
+		assert.Equal(t, http.StatusNotFound, get(server.Echo(), "/admin"))
+		assert.Equal(t, http.StatusOK, get(server.AdminEcho(), "/admin"))
+		assert.Equal(t, http.StatusNotFound, get(server.AdminEcho(), "/a"))
+		assert.Equal(t, []RouteInfo{
+			{Method: http.MethodGet, Path: "/a", Engine: "a"},
+			{Method: http.MethodGet, Path: "/admin", Engine: "admin"},
+		}, ctl.Routes())
+	})
+
+	t.Run("administrative routes are served on the server address when no admin address is configured", func(t *testing.T) {
+		server, _ := NewHTTPServer(engines(), runnerConfig("localhost:0"))
+
+		assert.Equal(t, http.StatusOK, get(server.Echo(), "/admin"))
+		assert.Nil(t, server.AdminEcho())
+	})
+
+	t.Run("serves until shut down", func(t *testing.T) {
+		cfg := runnerConfig("localhost:0")
+		cfg.v.Set(adminAddressFlag, "localhost:0")
+		server, _ := NewHTTPServer(engines(), cfg)
+
+		err := server.Start()
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		resp, err := testClient.Get(fmt.Sprintf("http://%s/admin", server.AdminAddr()))
+		if !assert.NoError(t, err) {
+			return
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, "admin", string(body))
+		assert.NotEqual(t, server.Addr(), server.AdminAddr())
+
+		assert.NoError(t, server.Shutdown(context.Background()))
+		_, err = testClient.Get(fmt.Sprintf("http://%s/admin", server.AdminAddr()))
+		assert.Error(t, err)
+		assert.Empty(t, server.Err())
+	})
+}
+
+func TestRecover(t *testing.T) {
+	e := echo.New()
+	e.Use(RequestLogger, Recover)
+	e.GET("/panic", func(c echo.Context) error {
+		panic("boom")
+	})
+	hook := test.NewGlobal()
+	defer hook.Reset()
+	rec := httptest.NewRecorder()
+
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/panic", nil))
+
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	entry := hook.LastEntry()
+	if !assert.NotNil(t, entry) {
+		return
+	}
+	assert.Equal(t, logrus.ErrorLevel, entry.Level)
+	assert.Equal(t, "HTTP request failed", entry.Message)
+	assert.Equal(t, http.StatusInternalServerError, entry.Data["status"])
+}
+
+func TestRequestLogger(t *testing.T) {
+	level := logrus.GetLevel()
+	defer logrus.SetLevel(level)
+	logrus.SetLevel(logrus.DebugLevel)
+	hook := test.NewGlobal()
+	defer hook.Reset()
+	e := echo.New()
+	e.Use(RequestLogger)
+	e.GET("/error", func(c echo.Context) error {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid")
+	})
+	rec := httptest.NewRecorder()
+
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/error?a=b", nil))
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	entry := hook.LastEntry()
+	if !assert.NotNil(t, entry) {
+		return
+	}
+	assert.Equal(t, logrus.DebugLevel, entry.Level)
+	assert.Equal(t, "GET", entry.Data["method"])
+	assert.Equal(t, "/error?a=b", entry.Data["uri"])
+	assert.Equal(t, http.StatusBadRequest, entry.Data["status"])
+}
@@ -0,0 +1,135 @@
+/*
+ * Nuts go core
+ * Copyright (C) 2020 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// UnixSocketScheme prefixes addresses of a unix domain socket, e.g. unix:///var/run/nuts/nuts.sock
+const UnixSocketScheme = "unix://"
+
+// socketFileMode is the mode of the socket file: only its owner and group may connect
+const socketFileMode os.FileMode = 0660
+
+// unixSocketURL is the base URL of a node reached through a unix domain socket, the host is ignored
+const unixSocketURL = "http://localhost"
+
+// ErrSocketInUse is returned when another process is listening on the unix domain socket
+var ErrSocketInUse = errors.New("socket in use")
+
+func isUnixSocket(address string) bool {
+	return strings.HasPrefix(address, UnixSocketScheme)
+}
+
+func unixSocketPath(address string) string {
+	return strings.TrimPrefix(address, UnixSocketScheme)
+}
+
+// listenUnix listens on the unix domain socket at the given path. A socket file left behind by a previous process is removed,
+// the new socket file can only be connected to by its owner and group. The file is removed when the listener is closed.
+func listenUnix(path string) (net.Listener, error) {
+	if path == "" {
+		return nil, fmt.Errorf("unable to listen on %s: missing socket path", UnixSocketScheme)
+	}
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("unable to listen on %s%s: %w", UnixSocketScheme, path, ErrSocketInUse)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("unable to remove stale socket %s: %w", path, err)
+		}
+	}
+
+	// the socket file is created with the mode of the process umask: create it in a directory only the owner can access
+	// and move it into place once its mode is set, so nobody else can connect in between
+	dir, err := ioutil.TempDir(filepath.Dir(path), ".sock")
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen on %s%s: %w", UnixSocketScheme, path, err)
+	}
+	defer os.RemoveAll(dir)
+	created := filepath.Join(dir, "s")
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: created, Net: "unix"})
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen on %s%s: %w", UnixSocketScheme, path, err)
+	}
+	// the file is removed by unixListener, by its final path
+	listener.SetUnlinkOnClose(false)
+	if err := os.Chmod(created, socketFileMode); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("unable to set permissions of socket %s: %w", path, err)
+	}
+	if err := os.Rename(created, path); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("unable to listen on %s%s: %w", UnixSocketScheme, path, err)
+	}
+	return &unixListener{UnixListener: listener, path: path}, nil
+}
+
+// unixListener is a listener on a socket file that was moved to the given path after it was created
+type unixListener struct {
+	*net.UnixListener
+	path  string
+	close sync.Once
+}
+
+// Addr returns the address of the socket at its final path
+func (l *unixListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: l.path, Net: "unix"}
+}
+
+// Close stops listening and removes the socket file
+func (l *unixListener) Close() error {
+	err := l.UnixListener.Close()
+	l.close.Do(func() {
+		os.Remove(l.path)
+	})
+	return err
+}
+
+// NodeClient returns the base URL of the node at the given address and an HTTP client to call it with, e.g. for engines in
+// client mode calling the node at ServerAddress. The address is a host and port, an http(s) URL or a unix domain socket
+// (unix:///var/run/nuts/nuts.sock), in which case the client connects to the socket and the base URL is http://localhost.
+func NodeClient(address string) (string, *http.Client) {
+	if isUnixSocket(address) {
+		path := unixSocketPath(address)
+		dialer := &net.Dialer{}
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, "unix", path)
+			},
+		}
+		return unixSocketURL, &http.Client{Transport: transport}
+	}
+	if !strings.HasPrefix(address, "http://") && !strings.HasPrefix(address, "https://") {
+		address = "http://" + address
+	}
+	return strings.TrimSuffix(address, "/"), &http.Client{}
+}
@@ -0,0 +1,181 @@
+/*
+ * Nuts go core
+ * Copyright (C) 2020 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package core
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getBody(t *testing.T, client *http.Client, url string) (int, string) {
+	resp, err := client.Get(url)
+	if !assert.NoError(t, err) {
+		return 0, ""
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestHTTPServer_UnixSocket(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "nuts-socket")
+	defer os.RemoveAll(dir)
+
+	t.Run("serves on a unix domain socket", func(t *testing.T) {
+		address := UnixSocketScheme + filepath.Join(dir, "serve.sock")
+		server := newTestServer(t, runnerConfig(address), authEngine())
+
+		if !assert.NoError(t, server.Start()) {
+			return
+		}
+		url, client := NodeClient(address)
+		status, body := getBody(t, client, url+"/protected")
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, SocketPrincipalName, body)
+		info, err := os.Stat(unixSocketPath(address))
+		if assert.NoError(t, err) {
+			assert.Equal(t, socketFileMode, info.Mode().Perm())
+		}
+
+		assert.NoError(t, server.Shutdown(context.Background()))
+		_, err = os.Stat(unixSocketPath(address))
+		assert.True(t, os.IsNotExist(err), "socket file is removed")
+	})
+
+	t.Run("socket requests are authenticated when enforced", func(t *testing.T) {
+		address := UnixSocketScheme + filepath.Join(dir, "admin.sock")
+		cfg := runnerConfig("localhost:0")
+		cfg.v.Set(adminAddressFlag, address)
+		cfg.v.Set(authEnforceFlag, true)
+		admin := authEngine()
+		admin.AdminRoutes = true
+		server := newTestServer(t, cfg, admin)
+
+		if !assert.NoError(t, server.Start()) {
+			return
+		}
+		defer server.Shutdown(context.Background())
+		url, client := NodeClient(address)
+		status, _ := getBody(t, client, url+"/protected")
+		assert.Equal(t, http.StatusOK, status)
+	})
+
+	t.Run("plain HTTP on a unix domain socket is allowed in strict mode", func(t *testing.T) {
+		cfg := runnerConfig(UnixSocketScheme + filepath.Join(dir, "strict.sock"))
+		cfg.v.Set(strictModeFlag, true)
+		server := newTestServer(t, cfg)
+
+		if assert.NoError(t, server.Start()) {
+			assert.NoError(t, server.Shutdown(context.Background()))
+		}
+	})
+
+	t.Run("plain HTTP on the server address isn't allowed in strict mode", func(t *testing.T) {
+		cfg := runnerConfig("localhost:0")
+		cfg.v.Set(adminAddressFlag, UnixSocketScheme+filepath.Join(dir, "strict.sock"))
+		cfg.v.Set(strictModeFlag, true)
+		server := newTestServer(t, cfg)
+
+		assert.Equal(t, ErrPlainHTTPInStrictMode, server.Start())
+	})
+
+	t.Run("stale socket file is replaced", func(t *testing.T) {
+		path := filepath.Join(dir, "stale.sock")
+		l, _ := net.Listen("unix", path)
+		l.(*net.UnixListener).SetUnlinkOnClose(false)
+		l.Close()
+
+		listener, err := listenUnix(path)
+
+		if assert.NoError(t, err) {
+			listener.Close()
+		}
+	})
+
+	t.Run("socket file is created with its mode and moved into place", func(t *testing.T) {
+		socketDir, _ := ioutil.TempDir(dir, "listen")
+		path := filepath.Join(socketDir, "listen.sock")
+
+		listener, err := listenUnix(path)
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, path, listener.Addr().String())
+		entries, _ := ioutil.ReadDir(socketDir)
+		if assert.Len(t, entries, 1, "temporary directory is removed") {
+			assert.Equal(t, "listen.sock", entries[0].Name())
+			assert.Equal(t, socketFileMode, entries[0].Mode().Perm())
+		}
+		conn, err := net.Dial("unix", path)
+		if assert.NoError(t, err) {
+			conn.Close()
+		}
+		assert.NoError(t, listener.Close())
+		_, err = os.Stat(path)
+		assert.True(t, os.IsNotExist(err), "socket file is removed")
+	})
+
+	t.Run("error when socket is in use", func(t *testing.T) {
+		path := filepath.Join(dir, "used.sock")
+		l, _ := net.Listen("unix", path)
+		defer l.Close()
+
+		_, err := listenUnix(path)
+
+		assert.True(t, errors.Is(err, ErrSocketInUse))
+	})
+
+	t.Run("error without path", func(t *testing.T) {
+		server := newTestServer(t, runnerConfig(UnixSocketScheme))
+
+		assert.EqualError(t, server.Start(), "unable to listen on unix://: missing socket path")
+	})
+}
+
+func TestNodeClient(t *testing.T) {
+	t.Run("host and port", func(t *testing.T) {
+		url, client := NodeClient("localhost:1323")
+
+		assert.Equal(t, "http://localhost:1323", url)
+		assert.NotNil(t, client)
+	})
+
+	t.Run("URL", func(t *testing.T) {
+		url, _ := NodeClient("https://nuts.example.com/")
+
+		assert.Equal(t, "https://nuts.example.com", url)
+	})
+
+	t.Run("unix domain socket", func(t *testing.T) {
+		url, client := NodeClient("unix:///var/run/nuts.sock")
+
+		assert.Equal(t, "http://localhost", url)
+		assert.NotNil(t, client.Transport)
+	})
+}
@@ -0,0 +1,132 @@
+/*
+ * Nuts go core
+ * Copyright (C) 2020 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package core
+
+import (
+	"time"
+)
+
+// EngineState is the lifecycle state of an engine
+type EngineState string
+
+const (
+	// EngineRegistered is the state of an engine which has been registered but not yet configured
+	EngineRegistered EngineState = "registered"
+	// EngineConfigured is the state of an engine which has been configured successfully
+	EngineConfigured EngineState = "configured"
+	// EngineStarted is the state of an engine which has been started successfully
+	EngineStarted EngineState = "started"
+	// EngineFailed is the state of an engine which returned an error from one of its lifecycle hooks
+	EngineFailed EngineState = "failed"
+	// EngineStopped is the state of an engine which has been shut down
+	EngineStopped EngineState = "stopped"
+	// EngineDisabled is the state of an engine which is disabled by configuration, see EngineControl.SelectEngines
+	EngineDisabled EngineState = "disabled"
+)
+
+// EngineStatus describes the lifecycle state of an engine
+type EngineStatus struct {
+	// Name is the name of the engine
+	Name string `json:"name"`
+	// State is the current lifecycle state of the engine
+	State EngineState `json:"state"`
+	// Since is the moment the engine entered its current state
+	Since time.Time `json:"since"`
+	// Timestamps holds the moment each state was last entered
+	Timestamps map[EngineState]time.Time `json:"timestamps"`
+	// LastError is the last error returned by the engine, empty if the engine never failed
+	LastError string `json:"lastError,omitempty"`
+	// LastErrorTime is the moment LastError occurred
+	LastErrorTime *time.Time `json:"lastErrorTime,omitempty"`
+	// Tasks holds the status of the engine's background tasks, see EngineControl.Supervisor
+	Tasks []TaskStatus `json:"tasks,omitempty"`
+}
+
+// Status returns the lifecycle status of the engine with the given Name or ConfigKey. It returns false if there's no such engine.
+func (ec *EngineControl) Status(nameOrKey string) (EngineStatus, bool) {
+	e := ec.Get(nameOrKey)
+	if e == nil {
+		return EngineStatus{}, false
+	}
+	return ec.status(e), true
+}
+
+// Statuses returns the lifecycle status of all registered engines, in registration order
+func (ec *EngineControl) Statuses() []EngineStatus {
+	engines := ec.All()
+	statuses := make([]EngineStatus, len(engines))
+	for i, e := range engines {
+		statuses[i] = ec.status(e)
+	}
+	return statuses
+}
+
+// status returns a copy of the status of the given engine
+func (ec *EngineControl) status(e *Engine) EngineStatus {
+	ec.stateMutex.RLock()
+	defer ec.stateMutex.RUnlock()
+
+	s, ok := ec.states[e]
+	if !ok {
+		// engine was added without Register
+		return EngineStatus{Name: e.Name, State: EngineRegistered, Timestamps: map[EngineState]time.Time{}}
+	}
+	result := *s
+	result.Timestamps = make(map[EngineState]time.Time, len(s.Timestamps))
+	for state, t := range s.Timestamps {
+		result.Timestamps[state] = t
+	}
+	if supervisor := ec.supervisors[e]; supervisor != nil {
+		result.Tasks = supervisor.Tasks()
+	}
+	return result
+}
+
+// transition moves the engine to the given state, err is recorded as last error when not nil
+func (ec *EngineControl) transition(e *Engine, state EngineState, err error) {
+	ec.stateMutex.Lock()
+	defer ec.stateMutex.Unlock()
+
+	if ec.states == nil {
+		ec.states = map[*Engine]*EngineStatus{}
+	}
+	s, ok := ec.states[e]
+	if !ok {
+		s = &EngineStatus{Name: e.Name, Timestamps: map[EngineState]time.Time{}}
+		ec.states[e] = s
+	}
+	now := time.Now()
+	s.State = state
+	s.Since = now
+	s.Timestamps[state] = now
+	if err != nil {
+		s.LastError = err.Error()
+		s.LastErrorTime = &now
+	}
+}
+
+// transitionOrFail moves the engine to the failed state when err is not nil, otherwise it moves the engine to the given state
+func (ec *EngineControl) transitionOrFail(e *Engine, state EngineState, err error) {
+	if err != nil {
+		ec.transition(e, EngineFailed, err)
+	} else {
+		ec.transition(e, state, nil)
+	}
+}
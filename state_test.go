@@ -0,0 +1,131 @@
+/*
+ * Nuts go core
+ * Copyright (C) 2020 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package core
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEngineControl_Status(t *testing.T) {
+	t.Run("registered engine", func(t *testing.T) {
+		ctl := EngineControl{}
+		ctl.Register(&Engine{Name: "A", ConfigKey: "a"})
+
+		status, ok := ctl.Status("a")
+
+		assert.True(t, ok)
+		assert.Equal(t, "A", status.Name)
+		assert.Equal(t, EngineRegistered, status.State)
+		assert.False(t, status.Since.IsZero())
+		assert.Empty(t, status.LastError)
+	})
+
+	t.Run("unknown engine", func(t *testing.T) {
+		_, ok := (&EngineControl{}).Status("A")
+
+		assert.False(t, ok)
+	})
+
+	t.Run("engine added without registering", func(t *testing.T) {
+		ctl := EngineControl{Engines: []*Engine{{Name: "A"}}}
+
+		status, ok := ctl.Status("A")
+
+		assert.True(t, ok)
+		assert.Equal(t, EngineRegistered, status.State)
+	})
+
+	t.Run("follows the lifecycle", func(t *testing.T) {
+		var calls []string
+		ctl := EngineControl{}
+		ctl.Register(recordingEngine("A", &calls, ""))
+
+		_ = ctl.Configure()
+		status, _ := ctl.Status("A")
+		assert.Equal(t, EngineConfigured, status.State)
+
+		_ = ctl.Start()
+		status, _ = ctl.Status("A")
+		assert.Equal(t, EngineStarted, status.State)
+
+		_ = ctl.Shutdown()
+		status, _ = ctl.Status("A")
+		assert.Equal(t, EngineStopped, status.State)
+		assert.Len(t, status.Timestamps, 4)
+		assert.Equal(t, status.Since, status.Timestamps[EngineStopped])
+	})
+
+	t.Run("failed engine has last error", func(t *testing.T) {
+		var calls []string
+		ctl := EngineControl{}
+		ctl.Register(recordingEngine("A", &calls, ""))
+		ctl.Register(recordingEngine("B", &calls, StartPhase))
+
+		_ = ctl.Start()
+
+		a, _ := ctl.Status("A")
+		assert.Equal(t, EngineStopped, a.State)
+		b, _ := ctl.Status("B")
+		assert.Equal(t, EngineFailed, b.State)
+		assert.Equal(t, "start failed", b.LastError)
+		assert.NotNil(t, b.LastErrorTime)
+	})
+
+	t.Run("returns a copy", func(t *testing.T) {
+		ctl := EngineControl{}
+		ctl.Register(&Engine{Name: "A"})
+
+		status, _ := ctl.Status("A")
+		status.Timestamps[EngineFailed] = status.Since
+
+		status, _ = ctl.Status("A")
+		assert.Len(t, status.Timestamps, 1)
+	})
+}
+
+func TestEngineControl_Statuses(t *testing.T) {
+	ctl := EngineControl{}
+	ctl.Register(&Engine{Name: "A"})
+	ctl.Register(&Engine{Name: "B"})
+
+	statuses := ctl.Statuses()
+
+	assert.Len(t, statuses, 2)
+	assert.Equal(t, "A", statuses[0].Name)
+	assert.Equal(t, "B", statuses[1].Name)
+}
+
+func TestEngineStatus_JSON(t *testing.T) {
+	ctl := EngineControl{}
+	ctl.Register(&Engine{Name: "A"})
+	status, _ := ctl.Status("A")
+
+	bytes, _ := json.Marshal(status)
+	var m map[string]interface{}
+	_ = json.Unmarshal(bytes, &m)
+
+	assert.Equal(t, "A", m["name"])
+	assert.Equal(t, "registered", m["state"])
+	assert.NotNil(t, m["since"])
+	assert.NotContains(t, m, "lastError")
+}
@@ -20,45 +20,173 @@
 package core
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
+	"text/tabwriter"
 
 	"github.com/labstack/echo/v4"
 	"github.com/spf13/cobra"
 )
 
-//NewStatusEngine creates a new Engine for viewing all engines
+//NewStatusEngine creates a new Engine for viewing all engines registered in EngineCtl
 func NewStatusEngine() *Engine {
+	return NewStatusEngineFor(&EngineCtl)
+}
+
+// NewStatusEngineFor creates a new Engine for viewing all engines registered in the given EngineControl
+func NewStatusEngineFor(engines *EngineControl) *Engine {
 	return &Engine{
-		Name: "Status",
-		Cmd: &cobra.Command{
-			Use:   "diagnostics",
-			Short: "show engine diagnostics",
-			Run: func(cmd *cobra.Command, args []string) {
-				diagnosticsSummaryAsText()
-			},
-		},
+		Name:        "Status",
+		ConfigKey:   "status",
+		AdminRoutes: true,
+		Cmd:         diagnosticsCommand(engines),
 		Diagnostics: func() []DiagnosticResult {
-			return []DiagnosticResult{diagnostics()}
+			return []DiagnosticResult{diagnostics(engines)}
 		},
 		Routes: func(router EchoRouter) {
-			router.GET("/status/diagnostics", diagnosticsOverview)
-			router.GET("/status", StatusOK)
+			router.GET("/status/diagnostics", diagnosticsOverview(engines))
+			router.GET("/status/engines", engineStatuses(engines))
+			router.GET("/status/routes", routeTable(engines))
+			router.GET("/status/openapi", openAPIDocument(engines))
+			router.GET("/status/openapi/engines", openAPIEngines(engines))
+			router.GET("/status/openapi/engines/:name", engineOpenAPIDocument(engines))
+			router.GET("/status", StatusOK, Public)
+		},
+	}
+}
+
+func diagnosticsCommand(engines *EngineControl) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "diagnostics",
+		Short: "show engine diagnostics",
+		Run: func(cmd *cobra.Command, args []string) {
+			diagnosticsSummaryAsText(engines)
+		},
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "routes",
+		Short: "show the HTTP routes of the engines",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			routes, err := RouteTable(engines)
+			if err != nil {
+				return err
+			}
+			cmd.Print(routeTableAsText(routes))
+			return nil
 		},
+	})
+	openAPICmd := &cobra.Command{
+		Use:   "openapi",
+		Short: "print the OpenAPI document of the node, or of a single engine",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var document map[string]interface{}
+			var err error
+			if name, _ := cmd.Flags().GetString("engine"); name != "" {
+				if document, err = EngineOpenAPI(engines, name); err == nil && document == nil {
+					err = fmt.Errorf("engine %s has no OpenAPI document", name)
+				}
+			} else {
+				document, err = OpenAPI(engines)
+			}
+			if err != nil {
+				return err
+			}
+			data, err := json.MarshalIndent(document, "", "  ")
+			if err != nil {
+				return err
+			}
+			cmd.Println(string(data))
+			return nil
+		},
+	}
+	openAPICmd.Flags().String("engine", "", "name of the engine to print the OpenAPI document of")
+	cmd.AddCommand(openAPICmd)
+	return cmd
+}
+
+func diagnosticsOverview(engines *EngineControl) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		return ctx.String(http.StatusOK, diagnosticsSummaryAsText(engines))
 	}
 }
 
-func diagnosticsOverview(ctx echo.Context) error {
-	return ctx.String(http.StatusOK, diagnosticsSummaryAsText())
+// engineStatuses returns the lifecycle status of all engines as JSON
+func engineStatuses(engines *EngineControl) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		return ctx.JSON(http.StatusOK, engines.Statuses())
+	}
 }
 
-func diagnosticsSummaryAsText() string {
+// routeTable returns the route table of the HTTP server as JSON
+func routeTable(engines *EngineControl) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		return ctx.JSON(http.StatusOK, engines.Routes())
+	}
+}
+
+// openAPIDocument returns the combined OpenAPI document of the engines
+func openAPIDocument(engines *EngineControl) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		document, err := OpenAPI(engines)
+		if err != nil {
+			return err
+		}
+		return ctx.JSON(http.StatusOK, document)
+	}
+}
+
+// openAPIEngines lists the engines providing an OpenAPI document
+func openAPIEngines(engines *EngineControl) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		infos, err := OpenAPIEngines(engines)
+		if err != nil {
+			return err
+		}
+		return ctx.JSON(http.StatusOK, infos)
+	}
+}
+
+// engineOpenAPIDocument returns the OpenAPI document of a single engine
+func engineOpenAPIDocument(engines *EngineControl) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		name := ctx.Param("name")
+		document, err := EngineOpenAPI(engines, name)
+		if err != nil {
+			return err
+		}
+		if document == nil {
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("engine %s has no OpenAPI document", name))
+		}
+		return ctx.JSON(http.StatusOK, document)
+	}
+}
+
+func routeTableAsText(routes []RouteInfo) string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "METHOD\tPATH\tENGINE")
+	for _, r := range routes {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", r.Method, r.Path, r.Engine)
+	}
+	w.Flush()
+	return b.String()
+}
+
+func diagnosticsSummaryAsText(engines *EngineControl) string {
 	var lines []string
-	for _, e := range EngineCtl.Engines {
+	for _, e := range engines.Enabled() {
+		var diagnostics []DiagnosticResult
 		if e.Diagnostics != nil {
+			diagnostics = e.Diagnostics()
+		}
+		supervisor := engines.existingSupervisor(e)
+		if supervisor != nil {
+			diagnostics = append(diagnostics, supervisor.Diagnostics()...)
+		}
+		if e.Diagnostics != nil || supervisor != nil {
 			lines = append(lines, e.Name)
-			diagnostics := e.Diagnostics()
 			for _, d := range diagnostics {
 				lines = append(lines, fmt.Sprintf("\t%s: %s", d.Name(), d.String()))
 			}
@@ -68,8 +196,8 @@ func diagnosticsSummaryAsText() string {
 	return strings.Join(lines, "\n")
 }
 
-func diagnostics() DiagnosticResult {
-	return &GenericDiagnosticResult{Title: "Registered engines", Outcome: strings.Join(listAllEngines(), ",")}
+func diagnostics(engines *EngineControl) DiagnosticResult {
+	return &GenericDiagnosticResult{Title: "Registered engines", Outcome: strings.Join(listAllEngines(engines), ",")}
 }
 
 // StatusOK returns 200 OK with a "OK" body
@@ -77,9 +205,9 @@ func StatusOK(ctx echo.Context) error {
 	return ctx.String(http.StatusOK, "OK")
 }
 
-func listAllEngines() []string {
+func listAllEngines(engines *EngineControl) []string {
 	var names []string
-	for _, e := range EngineCtl.Engines {
+	for _, e := range engines.All() {
 		names = append(names, e.Name)
 	}
 	return names
@@ -0,0 +1,252 @@
+/*
+ * Nuts go core
+ * Copyright (C) 2020 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultInitialBackoff = time.Second
+const defaultMaxBackoff = time.Minute
+
+// TaskState is the state of a supervised task
+type TaskState string
+
+const (
+	// TaskRunning is the state of a task which is running
+	TaskRunning TaskState = "running"
+	// TaskRestarting is the state of a task which failed with a recoverable error and waits to be restarted
+	TaskRestarting TaskState = "restarting"
+	// TaskCompleted is the state of a task which returned without error
+	TaskCompleted TaskState = "completed"
+	// TaskFailed is the state of a task which failed with a non-recoverable error or panicked
+	TaskFailed TaskState = "failed"
+	// TaskStopped is the state of a task which returned after the supervisor was stopped
+	TaskStopped TaskState = "stopped"
+)
+
+// TaskStatus describes the state of a supervised task
+type TaskStatus struct {
+	// Name is the name of the task
+	Name string `json:"name"`
+	// State is the current state of the task
+	State TaskState `json:"state"`
+	// Restarts is the number of times the task has been restarted
+	Restarts int `json:"restarts"`
+	// LastError is the last error returned by the task, empty if the task never failed
+	LastError string `json:"lastError,omitempty"`
+}
+
+// Supervisor runs the long-running background tasks of an engine. A task returning a recoverable Error is restarted
+// with exponential backoff. A task returning any other error or panicking marks the engine as failed.
+// The tasks are stopped when the engine is shut down.
+type Supervisor struct {
+	// InitialBackoff is the time to wait before the first restart of a failed task, it doubles on every consecutive failure
+	InitialBackoff time.Duration
+	// MaxBackoff is the maximum time to wait before restarting a failed task. A task which ran longer than MaxBackoff
+	// before failing is restarted after InitialBackoff again.
+	MaxBackoff time.Duration
+
+	onFailure func(err error)
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	mutex     sync.Mutex
+	tasks     []*TaskStatus
+}
+
+func newSupervisor(onFailure func(err error)) *Supervisor {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Supervisor{
+		InitialBackoff: defaultInitialBackoff,
+		MaxBackoff:     defaultMaxBackoff,
+		onFailure:      onFailure,
+		ctx:            ctx,
+		cancel:         cancel,
+	}
+}
+
+// Go runs the given task in the background until it returns without error, fails with a non-recoverable error,
+// or the supervisor is stopped. The task must return when the given context is done.
+func (s *Supervisor) Go(name string, task func(ctx context.Context) error) {
+	status := &TaskStatus{Name: name, State: TaskRunning}
+	s.mutex.Lock()
+	s.tasks = append(s.tasks, status)
+	s.mutex.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.supervise(status, task)
+	}()
+}
+
+func (s *Supervisor) supervise(status *TaskStatus, task func(ctx context.Context) error) {
+	backoff := s.InitialBackoff
+	for {
+		started := time.Now()
+		err := runTask(s.ctx, task)
+
+		if s.ctx.Err() != nil {
+			s.update(status, TaskStopped, nil)
+			return
+		}
+		if err == nil {
+			s.update(status, TaskCompleted, nil)
+			return
+		}
+
+		var coreErr Error
+		if !errors.As(err, &coreErr) || !coreErr.Recoverable() {
+			s.update(status, TaskFailed, err)
+			log.Errorf("Task %s failed: %v", status.Name, err)
+			if s.onFailure != nil {
+				s.onFailure(fmt.Errorf("task %s failed: %w", status.Name, err))
+			}
+			return
+		}
+
+		if time.Since(started) > s.MaxBackoff {
+			backoff = s.InitialBackoff
+		}
+		s.update(status, TaskRestarting, err)
+		log.Warnf("Task %s failed, restarting in %s: %v", status.Name, backoff, err)
+		select {
+		case <-s.ctx.Done():
+			s.update(status, TaskStopped, nil)
+			return
+		case <-time.After(backoff):
+		}
+		s.mutex.Lock()
+		status.State = TaskRunning
+		status.Restarts++
+		s.mutex.Unlock()
+
+		backoff *= 2
+		if backoff > s.MaxBackoff {
+			backoff = s.MaxBackoff
+		}
+	}
+}
+
+// runTask runs the task, converting a panic into a non-recoverable error
+func runTask(ctx context.Context, task func(ctx context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = Errorf("panic: %v", false, r)
+		}
+	}()
+	return task(ctx)
+}
+
+func (s *Supervisor) update(status *TaskStatus, state TaskState, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	status.State = state
+	if err != nil {
+		status.LastError = err.Error()
+	}
+}
+
+// Tasks returns the status of all tasks, in the order they were started
+func (s *Supervisor) Tasks() []TaskStatus {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	result := make([]TaskStatus, len(s.tasks))
+	for i, t := range s.tasks {
+		result[i] = *t
+	}
+	return result
+}
+
+// Diagnostics returns a DiagnosticResult for every task
+func (s *Supervisor) Diagnostics() []DiagnosticResult {
+	var results []DiagnosticResult
+	for _, t := range s.Tasks() {
+		outcome := fmt.Sprintf("%s, restarts: %d", t.State, t.Restarts)
+		if t.LastError != "" {
+			outcome += ", last error: " + t.LastError
+		}
+		results = append(results, &GenericDiagnosticResult{Title: "task " + t.Name, Outcome: outcome})
+	}
+	return results
+}
+
+// stop cancels the context of all tasks and waits for them to return until the given context is done
+func (s *Supervisor) stop(ctx context.Context) error {
+	s.cancel()
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%w: background tasks did not stop: %v", ErrShutdownTimeout, ctx.Err())
+	}
+}
+
+// Supervisor returns the Supervisor for the background tasks of the given engine. The tasks are stopped after the engine's
+// shutdown hook returns, or when the engine fails to start, within the engine's shutdown deadline. When a task fails with a
+// non-recoverable error, the engine's state becomes EngineFailed.
+func (ec *EngineControl) Supervisor(e *Engine) *Supervisor {
+	ec.stateMutex.Lock()
+	defer ec.stateMutex.Unlock()
+
+	if ec.supervisors == nil {
+		ec.supervisors = map[*Engine]*Supervisor{}
+	}
+	s, ok := ec.supervisors[e]
+	if !ok {
+		s = newSupervisor(func(err error) {
+			ec.transition(e, EngineFailed, err)
+		})
+		ec.supervisors[e] = s
+	}
+	return s
+}
+
+// stopSupervisor stops the background tasks of the engine, if any
+func (ec *EngineControl) stopSupervisor(ctx context.Context, e *Engine) error {
+	ec.stateMutex.Lock()
+	s := ec.supervisors[e]
+	delete(ec.supervisors, e)
+	ec.stateMutex.Unlock()
+
+	if s == nil {
+		return nil
+	}
+	return s.stop(ctx)
+}
+
+// existingSupervisor returns the Supervisor of the engine, nil if the engine has none
+func (ec *EngineControl) existingSupervisor(e *Engine) *Supervisor {
+	ec.stateMutex.RLock()
+	defer ec.stateMutex.RUnlock()
+	return ec.supervisors[e]
+}
@@ -0,0 +1,245 @@
+/*
+ * Nuts go core
+ * Copyright (C) 2020 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package core
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// waitForTask waits until the first task of the supervisor reaches the given state
+func waitForTask(t *testing.T, s *Supervisor, state TaskState) TaskStatus {
+	for i := 0; i < 200; i++ {
+		if tasks := s.Tasks(); len(tasks) > 0 && tasks[0].State == state {
+			return tasks[0]
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("task did not reach state %s, tasks: %v", state, s.Tasks())
+	return TaskStatus{}
+}
+
+func fastSupervisor(ctl *EngineControl, e *Engine) *Supervisor {
+	s := ctl.Supervisor(e)
+	s.InitialBackoff = time.Millisecond
+	s.MaxBackoff = 5 * time.Millisecond
+	return s
+}
+
+func TestSupervisor_Go(t *testing.T) {
+	t.Run("restarts task on recoverable error", func(t *testing.T) {
+		ctl := NewEngineControl()
+		e := &Engine{Name: "A"}
+		_ = ctl.Register(e)
+		s := fastSupervisor(ctl, e)
+		var runs int32
+
+		s.Go("sync", func(ctx context.Context) error {
+			if atomic.AddInt32(&runs, 1) < 3 {
+				return NewError("connection refused", true)
+			}
+			return nil
+		})
+
+		task := waitForTask(t, s, TaskCompleted)
+		assert.Equal(t, "sync", task.Name)
+		assert.Equal(t, 2, task.Restarts)
+		assert.Equal(t, "connection refused", task.LastError)
+		status, _ := ctl.Status("A")
+		assert.Equal(t, EngineRegistered, status.State)
+	})
+
+	t.Run("marks engine failed on non-recoverable error", func(t *testing.T) {
+		ctl := NewEngineControl()
+		e := &Engine{Name: "A"}
+		_ = ctl.Register(e)
+		s := fastSupervisor(ctl, e)
+
+		s.Go("sync", func(ctx context.Context) error {
+			return NewError("invalid data", false)
+		})
+
+		task := waitForTask(t, s, TaskFailed)
+		assert.Equal(t, 0, task.Restarts)
+		status, _ := ctl.Status("A")
+		assert.Equal(t, EngineFailed, status.State)
+		assert.Equal(t, "task sync failed: invalid data", status.LastError)
+	})
+
+	t.Run("plain errors are not recoverable", func(t *testing.T) {
+		ctl := NewEngineControl()
+		e := &Engine{Name: "A"}
+		s := fastSupervisor(ctl, e)
+
+		s.Go("sync", func(ctx context.Context) error {
+			return errors.New("failed")
+		})
+
+		waitForTask(t, s, TaskFailed)
+	})
+
+	t.Run("marks engine failed on panic", func(t *testing.T) {
+		ctl := NewEngineControl()
+		e := &Engine{Name: "A"}
+		_ = ctl.Register(e)
+		s := fastSupervisor(ctl, e)
+
+		s.Go("sync", func(ctx context.Context) error {
+			panic("boom")
+		})
+
+		task := waitForTask(t, s, TaskFailed)
+		assert.Equal(t, "panic: boom", task.LastError)
+		status, _ := ctl.Status("A")
+		assert.Equal(t, EngineFailed, status.State)
+	})
+
+	t.Run("same supervisor is returned for an engine", func(t *testing.T) {
+		ctl := NewEngineControl()
+		e := &Engine{Name: "A"}
+
+		assert.Same(t, ctl.Supervisor(e), ctl.Supervisor(e))
+	})
+}
+
+func TestSupervisor_Shutdown(t *testing.T) {
+	t.Run("tasks are stopped when the engine shuts down", func(t *testing.T) {
+		ctl := NewEngineControl()
+		e := &Engine{Name: "A"}
+		e.Start = func() error {
+			ctl.Supervisor(e).Go("sync", func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			})
+			return nil
+		}
+		_ = ctl.Register(e)
+		_ = ctl.Start()
+		s := ctl.Supervisor(e)
+
+		err := ctl.Shutdown()
+
+		assert.NoError(t, err)
+		task := s.Tasks()[0]
+		assert.Equal(t, TaskStopped, task.State)
+		assert.Empty(t, task.LastError)
+		assert.NotSame(t, s, ctl.Supervisor(e))
+	})
+
+	t.Run("tasks are stopped when the shutdown hook fails", func(t *testing.T) {
+		ctl := NewEngineControl()
+		e := &Engine{Name: "A", Shutdown: func() error {
+			return errors.New("b00m!")
+		}}
+		e.Start = func() error {
+			ctl.Supervisor(e).Go("sync", func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			})
+			return nil
+		}
+		assert.NoError(t, ctl.Register(e))
+		_ = ctl.Start()
+		s := ctl.Supervisor(e)
+
+		err := ctl.Shutdown()
+
+		assert.EqualError(t, err, "shutdown of engine A failed: b00m!")
+		assert.Equal(t, TaskStopped, s.Tasks()[0].State)
+	})
+
+	t.Run("tasks are stopped when the engine fails to start", func(t *testing.T) {
+		ctl := NewEngineControl()
+		e := &Engine{Name: "A"}
+		e.Start = func() error {
+			ctl.Supervisor(e).Go("sync", func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			})
+			return errors.New("b00m!")
+		}
+		assert.NoError(t, ctl.Register(e))
+		s := ctl.Supervisor(e)
+
+		err := ctl.Start()
+
+		assert.EqualError(t, err, "start of engine A failed: b00m!")
+		assert.Equal(t, TaskStopped, s.Tasks()[0].State)
+		assert.Nil(t, ctl.existingSupervisor(e))
+	})
+
+	t.Run("tasks not stopping in time are reported", func(t *testing.T) {
+		ctl := NewEngineControl()
+		e := &Engine{Name: "A", ShutdownTimeout: 10 * time.Millisecond}
+		done := make(chan struct{})
+		defer close(done)
+		e.Start = func() error {
+			ctl.Supervisor(e).Go("sync", func(ctx context.Context) error {
+				<-done
+				return nil
+			})
+			return nil
+		}
+		_ = ctl.Register(e)
+		_ = ctl.Start()
+
+		err := ctl.Shutdown()
+
+		assert.True(t, errors.Is(err, ErrShutdownTimeout))
+		assert.Contains(t, err.Error(), "background tasks did not stop")
+	})
+}
+
+func TestSupervisor_Diagnostics(t *testing.T) {
+	ctl := NewEngineControl()
+	e := &Engine{Name: "A"}
+	_ = ctl.Register(e)
+	s := fastSupervisor(ctl, e)
+	s.Go("sync", func(ctx context.Context) error {
+		return errors.New("failed")
+	})
+	waitForTask(t, s, TaskFailed)
+
+	t.Run("returns a result per task", func(t *testing.T) {
+		results := s.Diagnostics()
+
+		assert.Len(t, results, 1)
+		assert.Equal(t, "task sync", results[0].Name())
+		assert.Equal(t, "failed, restarts: 0, last error: failed", results[0].String())
+	})
+
+	t.Run("tasks are part of the diagnostics summary", func(t *testing.T) {
+		summary := diagnosticsSummaryAsText(ctl)
+
+		assert.True(t, strings.HasPrefix(summary, "A\n\ttask sync: failed"))
+	})
+
+	t.Run("tasks are part of the engine status", func(t *testing.T) {
+		status, _ := ctl.Status("A")
+
+		assert.Len(t, status.Tasks, 1)
+	})
+}
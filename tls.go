@@ -0,0 +1,200 @@
+/*
+ * Nuts go core
+ * Copyright (C) 2020 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package core
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const tlsCertFileFlag = "tls.certfile"
+const tlsKeyFileFlag = "tls.keyfile"
+const tlsCAFileFlag = "tls.cafile"
+const tlsClientAuthFlag = "tls.clientauth"
+const tlsMinVersionFlag = "tls.minversion"
+
+const defaultTLSClientAuth = "none"
+const defaultTLSMinVersion = "1.2"
+
+// ErrPlainHTTPInStrictMode is returned when the HTTP server is started without TLS in strict mode
+var ErrPlainHTTPInStrictMode = errors.New("plain HTTP is not allowed in strict mode, configure " + tlsCertFileFlag + " and " + tlsKeyFileFlag)
+
+// ErrInvalidTLSConfig is returned when the TLS options are invalid
+var ErrInvalidTLSConfig = errors.New("invalid TLS config")
+
+var tlsClientAuthTypes = map[string]tls.ClientAuthType{
+	"none":          tls.NoClientCert,
+	"request":       tls.RequestClientCert,
+	"require":       tls.RequireAnyClientCert,
+	"verifyifgiven": tls.VerifyClientCertIfGiven,
+	"verify":        tls.RequireAndVerifyClientCert,
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TLSOptions holds the TLS options of the node's HTTP server
+type TLSOptions struct {
+	// CertFile is the PEM file holding the server certificate (chain), TLS is enabled when set
+	CertFile string
+	// KeyFile is the PEM file holding the private key of the server certificate
+	KeyFile string
+	// CAFile is the PEM file holding the CA certificates client certificates are verified against
+	CAFile string
+	// ClientAuth is the client certificate policy: none, request, require, verifyifgiven or verify
+	ClientAuth string
+	// MinVersion is the minimum TLS version: 1.0, 1.1, 1.2 or 1.3
+	MinVersion string
+}
+
+// Enabled returns whether the server is to be served over TLS
+func (o TLSOptions) Enabled() bool {
+	return o.CertFile != ""
+}
+
+// validate checks the options without reading any files
+func (o TLSOptions) validate() error {
+	if !o.Enabled() {
+		return nil
+	}
+	if o.KeyFile == "" {
+		return fmt.Errorf("%w: %s is required when %s is set", ErrInvalidTLSConfig, tlsKeyFileFlag, tlsCertFileFlag)
+	}
+	clientAuth, ok := tlsClientAuthTypes[o.ClientAuth]
+	if !ok {
+		return fmt.Errorf("%w: unsupported %s: %s", ErrInvalidTLSConfig, tlsClientAuthFlag, o.ClientAuth)
+	}
+	if _, ok := tlsVersions[o.MinVersion]; !ok {
+		return fmt.Errorf("%w: unsupported %s: %s", ErrInvalidTLSConfig, tlsMinVersionFlag, o.MinVersion)
+	}
+	if (clientAuth == tls.VerifyClientCertIfGiven || clientAuth == tls.RequireAndVerifyClientCert) && o.CAFile == "" {
+		return fmt.Errorf("%w: %s is required to verify client certificates", ErrInvalidTLSConfig, tlsCAFileFlag)
+	}
+	return nil
+}
+
+// serverConfig returns the tls.Config for the HTTP server. The certificate, key and CA files are loaded immediately,
+// and loaded again during a handshake when they changed on disk.
+func (o TLSOptions) serverConfig() (*tls.Config, error) {
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	files := &tlsFiles{options: o}
+	if err := files.load(); err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		MinVersion: tlsVersions[o.MinVersion],
+		ClientAuth: tlsClientAuthTypes[o.ClientAuth],
+	}
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cert, pool := files.current()
+		c := config.Clone()
+		c.GetConfigForClient = nil
+		c.Certificates = []tls.Certificate{cert}
+		c.ClientCAs = pool
+		return c, nil
+	}
+	return config, nil
+}
+
+// tlsFiles holds the certificate and CA pool loaded from the configured files
+type tlsFiles struct {
+	options TLSOptions
+	mutex   sync.Mutex
+	modTime time.Time
+	cert    tls.Certificate
+	pool    *x509.CertPool
+}
+
+// current returns the certificate and CA pool, after loading them again when one of the files changed.
+// When loading fails, the previously loaded certificate and pool are returned until the files change again.
+func (f *tlsFiles) current() (tls.Certificate, *x509.CertPool) {
+	latest := latestModTime(f.options.CertFile, f.options.KeyFile, f.options.CAFile)
+	f.mutex.Lock()
+	changed := latest.After(f.modTime)
+	if changed {
+		// record the tried modification time, so a failing load isn't retried on every handshake
+		f.modTime = latest
+	}
+	f.mutex.Unlock()
+	if changed {
+		if err := f.load(); err != nil {
+			log.Errorf("Unable to reload TLS certificate, keeping the current one: %v", err)
+		} else {
+			log.Info("Reloaded TLS certificate")
+		}
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.cert, f.pool
+}
+
+func (f *tlsFiles) load() error {
+	modTime := latestModTime(f.options.CertFile, f.options.KeyFile, f.options.CAFile)
+	cert, err := tls.LoadX509KeyPair(f.options.CertFile, f.options.KeyFile)
+	if err != nil {
+		return fmt.Errorf("unable to load TLS certificate: %w", err)
+	}
+	var pool *x509.CertPool
+	if f.options.CAFile != "" {
+		data, err := ioutil.ReadFile(f.options.CAFile)
+		if err != nil {
+			return fmt.Errorf("unable to load TLS CA file: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("%w: no certificates found in %s", ErrInvalidTLSConfig, f.options.CAFile)
+		}
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.cert = cert
+	f.pool = pool
+	f.modTime = modTime
+	return nil
+}
+
+// latestModTime returns the latest modification time of the given files, files that don't exist are skipped
+func latestModTime(files ...string) time.Time {
+	var latest time.Time
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+		if info, err := os.Stat(file); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
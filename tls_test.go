@@ -0,0 +1,319 @@
+/*
+ * Nuts go core
+ * Copyright (C) 2020 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package core
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testPKI holds a CA and files for a server and client certificate issued by it
+type testPKI struct {
+	dir    string
+	ca     *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	caFile string
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	dir, err := ioutil.TempDir("", "tls")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	p := &testPKI{dir: dir, caFile: filepath.Join(dir, "ca.pem")}
+	p.caKey, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &p.caKey.PublicKey, p.caKey)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	p.ca, _ = x509.ParseCertificate(der)
+	p.write(t, p.caFile, "CERTIFICATE", der)
+	return p
+}
+
+// issue writes a certificate and key issued by the CA to <name>.pem and <name>-key.pem and returns the file names
+func (p *testPKI) issue(t *testing.T, name string, serial int64) (string, string) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:     []string{"localhost"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, p.ca, &key.PublicKey, p.caKey)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	certFile := filepath.Join(p.dir, name+".pem")
+	keyFile := filepath.Join(p.dir, name+"-key.pem")
+	p.write(t, certFile, "CERTIFICATE", der)
+	p.write(t, keyFile, "EC PRIVATE KEY", keyDER)
+	return certFile, keyFile
+}
+
+func (p *testPKI) write(t *testing.T, file string, blockType string, der []byte) {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if !assert.NoError(t, ioutil.WriteFile(file, data, 0600)) {
+		t.FailNow()
+	}
+}
+
+func (p *testPKI) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(p.ca)
+	return pool
+}
+
+// tlsClient returns a client trusting the test CA, presenting the given client certificate if not nil
+func (p *testPKI) tlsClient(cert *tls.Certificate) *http.Client {
+	config := &tls.Config{RootCAs: p.pool()}
+	if cert != nil {
+		config.Certificates = []tls.Certificate{*cert}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: config, DisableKeepAlives: true}}
+}
+
+func tlsServerConfig(certFile string, keyFile string) *NutsGlobalConfig {
+	cfg := runnerConfig("localhost:0")
+	cfg.v.Set(tlsCertFileFlag, certFile)
+	cfg.v.Set(tlsKeyFileFlag, keyFile)
+	cfg.v.Set(tlsClientAuthFlag, defaultTLSClientAuth)
+	cfg.v.Set(tlsMinVersionFlag, defaultTLSMinVersion)
+	return cfg
+}
+
+func startTLSServer(t *testing.T, cfg NutsConfigValues) *HTTPServer {
+	ctl := NewEngineControl()
+	ctl.Register(runnerEngine("a", &syncCalls{}))
+	server, _ := NewHTTPServer(ctl, cfg)
+	if !assert.NoError(t, server.Start()) {
+		t.FailNow()
+	}
+	return server
+}
+
+func TestTLSOptions_validate(t *testing.T) {
+	valid := TLSOptions{CertFile: "c", KeyFile: "k", ClientAuth: "none", MinVersion: "1.2"}
+
+	t.Run("ok", func(t *testing.T) {
+		assert.NoError(t, valid.validate())
+	})
+
+	t.Run("ok when disabled", func(t *testing.T) {
+		assert.NoError(t, TLSOptions{}.validate())
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		tests := map[string]func(o *TLSOptions){
+			"tls.keyfile is required when tls.certfile is set":     func(o *TLSOptions) { o.KeyFile = "" },
+			"unsupported tls.clientauth: always":                   func(o *TLSOptions) { o.ClientAuth = "always" },
+			"unsupported tls.minversion: 2.0":                      func(o *TLSOptions) { o.MinVersion = "2.0" },
+			"tls.cafile is required to verify client certificates": func(o *TLSOptions) { o.ClientAuth = "verify" },
+		}
+		for expected, modify := range tests {
+			o := valid
+			modify(&o)
+
+			err := o.validate()
+
+			assert.True(t, errors.Is(err, ErrInvalidTLSConfig))
+			assert.EqualError(t, err, "invalid TLS config: "+expected)
+		}
+	})
+}
+
+func TestHTTPServer_TLS(t *testing.T) {
+	pki := newTestPKI(t)
+	defer os.RemoveAll(pki.dir)
+	certFile, keyFile := pki.issue(t, "server", 2)
+	clientCertFile, clientKeyFile := pki.issue(t, "client", 3)
+	clientCert, _ := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+
+	t.Run("serves over TLS", func(t *testing.T) {
+		server := startTLSServer(t, tlsServerConfig(certFile, keyFile))
+		defer server.Shutdown(context.Background())
+
+		resp, err := pki.tlsClient(nil).Get(fmt.Sprintf("https://%s/a", server.Addr()))
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "server", resp.TLS.PeerCertificates[0].Subject.CommonName)
+		assert.Equal(t, uint16(tls.VersionTLS13), resp.TLS.Version)
+	})
+
+	t.Run("minimum version is enforced", func(t *testing.T) {
+		cfg := tlsServerConfig(certFile, keyFile)
+		cfg.v.Set(tlsMinVersionFlag, "1.3")
+		server := startTLSServer(t, cfg)
+		defer server.Shutdown(context.Background())
+		client := pki.tlsClient(nil)
+		client.Transport.(*http.Transport).TLSClientConfig.MaxVersion = tls.VersionTLS12
+
+		_, err := client.Get(fmt.Sprintf("https://%s/a", server.Addr()))
+
+		assert.Error(t, err)
+	})
+
+	t.Run("verifies client certificates", func(t *testing.T) {
+		cfg := tlsServerConfig(certFile, keyFile)
+		cfg.v.Set(tlsCAFileFlag, pki.caFile)
+		cfg.v.Set(tlsClientAuthFlag, "verify")
+		server := startTLSServer(t, cfg)
+		defer server.Shutdown(context.Background())
+		url := fmt.Sprintf("https://%s/a", server.Addr())
+
+		_, err := pki.tlsClient(nil).Get(url)
+		assert.Error(t, err)
+
+		resp, err := pki.tlsClient(&clientCert).Get(url)
+		if assert.NoError(t, err) {
+			resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		}
+	})
+
+	t.Run("reloads changed certificate", func(t *testing.T) {
+		reloadCert, reloadKey := pki.issue(t, "reload", 4)
+		server := startTLSServer(t, tlsServerConfig(reloadCert, reloadKey))
+		defer server.Shutdown(context.Background())
+		url := fmt.Sprintf("https://%s/a", server.Addr())
+
+		pki.issue(t, "reload", 5)
+		future := time.Now().Add(time.Minute)
+		_ = os.Chtimes(reloadCert, future, future)
+
+		resp, err := pki.tlsClient(nil).Get(url)
+		if assert.NoError(t, err) {
+			resp.Body.Close()
+			assert.Equal(t, int64(5), resp.TLS.PeerCertificates[0].SerialNumber.Int64())
+		}
+	})
+
+	t.Run("keeps current certificate when reload fails", func(t *testing.T) {
+		reloadCert, reloadKey := pki.issue(t, "broken", 6)
+		server := startTLSServer(t, tlsServerConfig(reloadCert, reloadKey))
+		defer server.Shutdown(context.Background())
+
+		_ = ioutil.WriteFile(reloadCert, []byte("broken"), 0600)
+		future := time.Now().Add(time.Minute)
+		_ = os.Chtimes(reloadCert, future, future)
+
+		resp, err := pki.tlsClient(nil).Get(fmt.Sprintf("https://%s/a", server.Addr()))
+		if assert.NoError(t, err) {
+			resp.Body.Close()
+			assert.Equal(t, int64(6), resp.TLS.PeerCertificates[0].SerialNumber.Int64())
+		}
+	})
+
+	t.Run("failed reload is retried when the files change again", func(t *testing.T) {
+		reloadCert, reloadKey := pki.issue(t, "retry", 7)
+		server := startTLSServer(t, tlsServerConfig(reloadCert, reloadKey))
+		defer server.Shutdown(context.Background())
+		url := fmt.Sprintf("https://%s/a", server.Addr())
+		serial := func() int64 {
+			resp, err := pki.tlsClient(nil).Get(url)
+			if !assert.NoError(t, err) {
+				return 0
+			}
+			resp.Body.Close()
+			return resp.TLS.PeerCertificates[0].SerialNumber.Int64()
+		}
+
+		_ = ioutil.WriteFile(reloadCert, []byte("broken"), 0600)
+		future := time.Now().Add(time.Minute)
+		_ = os.Chtimes(reloadCert, future, future)
+		assert.Equal(t, int64(7), serial())
+
+		// fixed without changing the modification time: not tried again
+		pki.issue(t, "retry", 8)
+		_ = os.Chtimes(reloadCert, future, future)
+		_ = os.Chtimes(reloadKey, future, future)
+		assert.Equal(t, int64(7), serial())
+
+		later := future.Add(time.Minute)
+		_ = os.Chtimes(reloadCert, later, later)
+		assert.Equal(t, int64(8), serial())
+	})
+
+	t.Run("error when certificate can't be loaded", func(t *testing.T) {
+		ctl := NewEngineControl()
+		server, _ := NewHTTPServer(ctl, tlsServerConfig(filepath.Join(pki.dir, "unknown.pem"), keyFile))
+
+		err := server.Start()
+
+		assert.Contains(t, err.Error(), "unable to load TLS certificate")
+	})
+
+	t.Run("plain HTTP is refused in strict mode", func(t *testing.T) {
+		cfg := runnerConfig("localhost:0")
+		cfg.v.Set(strictModeFlag, true)
+		server, _ := NewHTTPServer(NewEngineControl(), cfg)
+
+		err := server.Start()
+
+		assert.Equal(t, ErrPlainHTTPInStrictMode, err)
+	})
+
+	t.Run("TLS is allowed in strict mode", func(t *testing.T) {
+		cfg := tlsServerConfig(certFile, keyFile)
+		cfg.v.Set(strictModeFlag, true)
+		cfg.v.Set(tlsCAFileFlag, pki.caFile)
+		cfg.v.Set(tlsClientAuthFlag, "verify")
+		cfg.v.Set(authClientCertFlag, true)
+
+		server := startTLSServer(t, cfg)
+
+		assert.NoError(t, server.Shutdown(context.Background()))
+	})
+}